@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+type manifestEntry struct {
+	url  string
+	name string
+}
+
+// parseManifest reads one "url[ name]" entry per line. Blank lines and lines
+// starting with # are skipped.
+func parseManifest(r io.Reader) ([]manifestEntry, error) {
+	var entries []manifestEntry
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		url, name, _ := strings.Cut(text, " ")
+		if name = strings.TrimSpace(name); name == "-" {
+			return nil, fmt.Errorf("line %d: can't write a manifest entry to stdout", line)
+		}
+		entries = append(entries, manifestEntry{url: url, name: name})
+	}
+	return entries, scanner.Err()
+}
+
+func readManifest(path string) ([]manifestEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseManifest(file)
+}
+
+type batchResult struct {
+	entry manifestEntry
+	err   error
+}
+
+// runBatch downloads entries with at most jobs files in flight. The
+// connections in opts.Concurrency are split between them, so the whole batch
+// never holds more than that. If view is set every file gets a row in it.
+func runBatch(ctx context.Context, d *downloader.Downloader, entries []manifestEntry, jobs int, opts downloader.Options, view *batchView) []batchResult {
+	jobs = max(min(jobs, len(entries), opts.Concurrency), 1)
+	opts.Concurrency = max(opts.Concurrency/jobs, 1)
+
+	results := make([]batchResult, len(entries))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				entry := entries[i]
+				if view == nil {
+					results[i] = batchResult{entry: entry, err: d.Download(ctx, entry.url, entry.name, opts)}
+					continue
+				}
+				row := view.start(entry)
+				opts := opts
+				opts.Status = row.status
+				results[i] = batchResult{entry: entry, err: d.Download(ctx, entry.url, entry.name, opts)}
+				view.finish(row)
+			}
+		}()
+	}
+	for i := range entries {
+		if ctx.Err() != nil {
+			results[i] = batchResult{entry: entries[i], err: ctx.Err()}
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// printSummary lists how every entry ended and returns the number of
+// failures
+func printSummary(w io.Writer, results []batchResult) int {
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.err == nil:
+			fmt.Fprintln(w, "ok     ", r.entry.url)
+		case errors.Is(r.err, downloader.ErrUpToDate):
+			fmt.Fprintln(w, "current", r.entry.url)
+		case errors.Is(r.err, downloader.ErrExists):
+			fmt.Fprintln(w, "exists ", r.entry.url)
+		default:
+			failed++
+			fmt.Fprintln(w, "FAILED ", r.entry.url, "-", r.err)
+		}
+	}
+	fmt.Fprintf(w, "%d of %d downloads failed\n", failed, len(results))
+	return failed
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+// Values of -compress
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+)
+
+// gzipName is where a file saved as name ends up compressed
+func gzipName(name string) string {
+	if strings.HasSuffix(name, ".gz") {
+		return name
+	}
+	return name + ".gz"
+}
+
+// magics are the first bytes of formats that don't get any smaller
+var magics = []struct {
+	format string
+	magic  []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"zip", []byte("PK\x03\x04")},
+	{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{"bzip2", []byte("BZh")},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{"7z", []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}},
+}
+
+// compressedFormat names the compression format head starts with, if any
+func compressedFormat(head []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+	return ""
+}
+
+// sniffer hands the first bytes written through it to check, once
+type sniffer struct {
+	w     io.Writer
+	head  []byte
+	check func(head []byte)
+}
+
+func (s *sniffer) Write(p []byte) (int, error) {
+	if s.check != nil {
+		s.head = append(s.head, p[:min(len(p), 8-len(s.head))]...)
+		if len(s.head) == 8 {
+			s.check(s.head)
+			s.check = nil
+		}
+	}
+	return s.w.Write(p)
+}
+
+// flush checks whatever arrived if the file was shorter than the sniffed
+// bytes
+func (s *sniffer) flush() {
+	if s.check != nil && len(s.head) > 0 {
+		s.check(s.head)
+	}
+	s.check = nil
+}
+
+// downloadCompressed streams url through gzip into gzipName(name). Gzip
+// can only be written front to back, so this takes a single request.
+func downloadCompressed(ctx context.Context, d *downloader.Downloader, url, name string, opts downloader.Options) error {
+	if opts.OutputDir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(opts.OutputDir, name)
+	}
+	dest := gzipName(name)
+	if !opts.Override {
+		if _, err := os.Stat(dest); err == nil {
+			opts.Logger.Error(dest, "exists make sure the *override* flag is set to continue")
+			return downloader.ErrExists
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	temp := dest + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(file)
+	zw.Name = filepath.Base(name)
+	s := &sniffer{w: zw, check: func(head []byte) {
+		if format := compressedFormat(head); format != "" {
+			opts.Logger.Info("WARNING: the file is already", format, "compressed, gzip won't make it smaller")
+		}
+	}}
+	err = d.DownloadTo(ctx, url, s, opts)
+	s.flush()
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// temp sits in the same directory, so dest appears all at once
+		err = os.Rename(temp, dest)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	opts.Logger.Info("Saved to", dest)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+func TestGzipName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"file.iso", "file.iso.gz"},
+		{"file", "file.gz"},
+		{"file.gz", "file.gz"},
+	}
+	for _, tt := range tests {
+		if got := gzipName(tt.in); got != tt.want {
+			t.Errorf("gzipName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCompressedFormat(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("hello"))
+	zw.Close()
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{name: "gzip", head: gz.Bytes(), want: "gzip"},
+		{name: "zip", head: []byte("PK\x03\x04rest"), want: "zip"},
+		{name: "xz", head: []byte("\xfd7zXZ\x00\x00"), want: "xz"},
+		{name: "text", head: []byte("hello world")},
+		{name: "empty"},
+	}
+	for _, tt := range tests {
+		if got := compressedFormat(tt.head); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDownloadCompressed(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(bytes.Repeat([]byte("already compressed "), 1000))
+	zw.Close()
+	tests := []struct {
+		name string
+		data []byte
+		warn bool
+	}{
+		{name: "plain", data: bytes.Repeat([]byte("some text "), 100000)},
+		{name: "already compressed", data: gz.Bytes(), warn: true},
+		{name: "tiny", data: []byte("hi")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(tt.data))
+			}))
+			defer srv.Close()
+
+			var logs bytes.Buffer
+			opts := downloader.Options{Logger: downloader.NewLogger(log.New(&logs, "", 0), downloader.LevelInfo)}
+			name := filepath.Join(t.TempDir(), "file")
+			if err := downloadCompressed(context.Background(), &downloader.Downloader{}, srv.URL, name, opts); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(name + ".gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			zr, err := gzip.NewReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := io.ReadAll(zr); !bytes.Equal(got, tt.data) {
+				t.Error("decompressed file doesn't match the served one")
+			}
+			if warned := strings.Contains(logs.String(), "already gzip compressed"); warned != tt.warn {
+				t.Errorf("warned %v, want %v, logs %q", warned, tt.warn, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// defaultConfig is read when -config isn't given and the file exists
+const defaultConfig = ".downloaderrc"
+
+// loadConfig applies the JSON config file at path to the flags that
+// weren't set on the command line. Without an explicit path the default
+// config in the home directory is used if there is one.
+func loadConfig(flags *flag.FlagSet, path string) error {
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, defaultConfig)
+	}
+	data, err := os.ReadFile(path)
+	if !explicit && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := applyConfig(flags, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// applyConfig reads a JSON object whose keys are flag names. A value may be
+// a string, number or bool, or an array of them for a flag that can be
+// repeated such as url and header. Flags already set win over the file.
+func applyConfig(flags *flag.FlagSet, r io.Reader) error {
+	var values map[string]json.RawMessage
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// Sorted so the first unknown key reported is always the same
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if flags.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("unknown key %q", key)
+		}
+		if set[key] {
+			continue
+		}
+		items, err := configValues(values[key])
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		for _, item := range items {
+			if err := flags.Set(key, item); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues turns a JSON value into the strings to pass to flag.Set
+func configValues(raw json.RawMessage) ([]string, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	list, ok := v.([]any)
+	if !ok {
+		list = []any{v}
+	}
+
+	items := make([]string, len(list))
+	for i, v := range list {
+		switch v := v.(type) {
+		case string:
+			items[i] = v
+		case json.Number, bool:
+			items[i] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("expected a string, number or bool, got %s", strings.TrimSpace(string(raw)))
+		}
+	}
+	return items, nil
+}
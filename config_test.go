@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testFlags() (*flag.FlagSet, *string, *int, *bool, *listFlag, headerFlag) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	name := fs.String("name", "", "")
+	conc := fs.Int("conc", 10, "")
+	quiet := fs.Bool("quiet", false, "")
+	var urls listFlag
+	fs.Var(&urls, "url", "")
+	headers := headerFlag(http.Header{})
+	fs.Var(headers, "header", "")
+	fs.String("config", "", "")
+	return fs, name, conc, quiet, &urls, headers
+}
+
+func TestApplyConfig(t *testing.T) {
+	fs, name, conc, quiet, urls, headers := testFlags()
+	if err := fs.Parse([]string{"-conc", "3"}); err != nil {
+		t.Fatal(err)
+	}
+	config := `{
+		"name": "out.bin",
+		"conc": 20,
+		"quiet": true,
+		"url": ["http://a/file", "http://b/file"],
+		"header": ["X-One: 1", "X-Two: 2"]
+	}`
+	if err := applyConfig(fs, strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	if *name != "out.bin" {
+		t.Errorf("name = %q", *name)
+	}
+	if *conc != 3 {
+		t.Errorf("conc = %d, the command line should win", *conc)
+	}
+	if !*quiet {
+		t.Error("quiet not set")
+	}
+	if want := (listFlag{"http://a/file", "http://b/file"}); !reflect.DeepEqual(*urls, want) {
+		t.Errorf("url = %v, want %v", *urls, want)
+	}
+	if got := http.Header(headers).Get("X-Two"); got != "2" {
+		t.Errorf("X-Two = %q", got)
+	}
+}
+
+func TestApplyConfigErrors(t *testing.T) {
+	tests := []struct {
+		name, config, want string
+	}{
+		{name: "unknown key", config: `{"conc": 2, "concurrency": 4}`, want: `unknown key "concurrency"`},
+		{name: "config in config", config: `{"config": "other.json"}`, want: `unknown key "config"`},
+		{name: "bad value", config: `{"conc": "many"}`, want: "conc:"},
+		{name: "object value", config: `{"name": {"a": 1}}`, want: "expected a string"},
+		{name: "not an object", config: `["conc"]`, want: "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, _, _, _, _, _ := testFlags()
+			err := applyConfig(fs, strings.NewReader(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+// newCookieJar returns a jar filled from the Netscape cookie file at path,
+// if there is one
+func newCookieJar(path string) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return jar, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if err := downloader.LoadCookies(jar, file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return jar, nil
+}
+
+// addCookies puts cookies into jar for the hosts of rawURLs only, so they
+// don't follow a redirect anywhere else
+func addCookies(jar http.CookieJar, cookies []*http.Cookie, rawURLs []string) error {
+	for _, rawURL := range rawURLs {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return err
+		}
+		jar.SetCookies(u, cookies)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package downloader
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Workers an Adaptive download starts with
+	adaptiveStart = 2
+	// How often the number of workers is reconsidered by default
+	defaultAdaptInterval = time.Second
+	// An added worker has to raise the throughput by this much to stay
+	adaptiveGain = 1.05
+	// Throughput falling under this share of the previous step means the
+	// link or server is overloaded
+	adaptiveDrop = 0.9
+	// Steps without a change before another worker is tried
+	adaptiveProbe = 5
+)
+
+// aimd picks how many workers to run from the throughput they reach: one
+// more for as long as that helps, the added one taken away again when it
+// doesn't, and a quarter less when throughput drops on its own
+type aimd struct {
+	max, target int
+	// Throughput of the previous step
+	last float64
+	// Whether the previous step added (1) or took away (-1) workers
+	dir    int
+	steady int
+}
+
+func newAIMD(start, max int) aimd {
+	return aimd{max: max, target: min(start, max)}
+}
+
+// step takes the throughput reached since the previous step and returns
+// how many workers to run now
+func (a *aimd) step(rate float64) int {
+	prev, dir := a.last, a.dir
+	a.last, a.dir = rate, 0
+	switch {
+	case prev == 0 || dir > 0 && rate >= prev*adaptiveGain:
+		a.grow()
+	case dir > 0:
+		a.target--
+		a.dir = -1
+	case dir < 0:
+		// Fewer workers fetch less, that is the new baseline
+	case rate < prev*adaptiveDrop:
+		a.target = max(a.target*3/4, 1)
+		a.dir = -1
+	default:
+		if a.steady++; a.steady >= adaptiveProbe {
+			a.grow()
+		}
+	}
+	return a.target
+}
+
+func (a *aimd) grow() {
+	a.steady = 0
+	if a.target < a.max {
+		a.target++
+		a.dir = 1
+	}
+}
+
+// adaptiveWorkers runs the workers of an Adaptive download under an aimd
+type adaptiveWorkers struct {
+	aimd
+	// Bytes written since the previous step
+	fetched atomic.Int64
+	// Workers still to stop after their current chunk
+	retire atomic.Int64
+}
+
+func newAdaptiveWorkers(most int) *adaptiveWorkers {
+	return &adaptiveWorkers{aimd: newAIMD(adaptiveStart, most)}
+}
+
+// counting wraps the progress func of a worker to count its bytes
+func (a *adaptiveWorkers) counting(progress func(n int)) func(n int) {
+	return func(n int) {
+		a.fetched.Add(int64(n))
+		progress(n)
+	}
+}
+
+// retired reports whether the calling worker has to stop
+func (a *adaptiveWorkers) retired() bool {
+	for {
+		n := a.retire.Load()
+		if n <= 0 {
+			return false
+		}
+		if a.retire.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// run measures the throughput every interval and starts workers with
+// spawn or retires them to match the aimd, until ctx is done or done
+// reports that there is nothing left to hand out
+func (a *adaptiveWorkers) run(ctx context.Context, interval time.Duration, spawn func(), done func() bool, logger Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	running := a.target
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if done() {
+				return
+			}
+			rate := float64(a.fetched.Swap(0)) / now.Sub(last).Seconds()
+			last = now
+			target := a.step(rate)
+			if target != running {
+				logger.Debug("Adjusting workers from", running, "to", target, "at", int64(rate), "bytes/s")
+			}
+			for ; running < target; running++ {
+				spawn()
+			}
+			if running > target {
+				a.retire.Add(int64(running - target))
+				running = target
+			}
+		}
+	}
+}
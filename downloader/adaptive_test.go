@@ -0,0 +1,76 @@
+package downloader
+
+import (
+	"bytes"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAIMD(t *testing.T) {
+	tests := []struct {
+		name  string
+		rates []float64
+		want  []int
+	}{
+		{name: "keeps growing", rates: []float64{100, 200, 300, 400}, want: []int{3, 4, 5, 6}},
+		{name: "capped", rates: []float64{100, 200, 300, 400, 500, 600, 700}, want: []int{3, 4, 5, 6, 7, 8, 8}},
+		{name: "no gain", rates: []float64{100, 200, 202, 180, 180}, want: []int{3, 4, 3, 3, 3}},
+		{name: "probes again", rates: []float64{100, 100, 100, 100, 100, 100, 100, 100, 100}, want: []int{3, 2, 2, 2, 2, 2, 2, 3, 2}},
+		{name: "drop", rates: []float64{100, 200, 200, 200, 100, 80}, want: []int{3, 4, 3, 3, 2, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAIMD(2, 8)
+			for i, rate := range tt.rates {
+				if got := a.step(rate); got != tt.want[i] {
+					t.Fatalf("step %d at %v = %d workers, want %d", i, rate, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// throttledWriter holds back every write of a response, as if each
+// connection were slow
+type throttledWriter struct {
+	http.ResponseWriter
+}
+
+func (w throttledWriter) Write(p []byte) (int, error) {
+	time.Sleep(10 * time.Millisecond)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestDownloadAdaptive(t *testing.T) {
+	data := testData(8 << 20)
+	var active, peak atomic.Int32
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			serveData(data)(w, r)
+			return
+		}
+		n := active.Add(1)
+		defer active.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		serveData(data)(throttledWriter{w}, r)
+	}))
+
+	opts := testOptions()
+	opts.Concurrency = 8
+	opts.ChunkSize = 64 << 10
+	opts.Adaptive = true
+	opts.adaptInterval = 50 * time.Millisecond
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+	if p := peak.Load(); p <= adaptiveStart || p > int32(opts.Concurrency) {
+		t.Errorf("ran up to %d requests at once, want more than %d and at most %d", p, adaptiveStart, opts.Concurrency)
+	}
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Block is a piece of the remote file with a known digest, see
+// Options.Blocks
+type Block struct {
+	Offset, Length uint64
+	Checksum       *Checksum
+}
+
+// End is the offset of the block's last byte
+func (b Block) End() uint64 {
+	return b.Offset + b.Length - 1
+}
+
+// ParseBlockManifest reads one "offset,length,sha256" line per block, the
+// digest in hex. Blank lines and lines starting with # are skipped. Blocks
+// have to be in order and must not overlap.
+func ParseBlockManifest(r io.Reader) ([]Block, error) {
+	var blocks []Block
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected offset,length,sha256", line)
+		}
+		offset, err := strconv.ParseUint(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid offset %q", line, fields[0])
+		}
+		length, err := strconv.ParseUint(strings.TrimSpace(fields[1]), 10, 64)
+		if err != nil || length == 0 {
+			return nil, fmt.Errorf("line %d: invalid length %q", line, fields[1])
+		}
+		sum, err := NewChecksum("sha256", fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if n := len(blocks); n > 0 && offset <= blocks[n-1].End() {
+			return nil, fmt.Errorf("line %d: block at %d overlaps or comes before the one at %d", line, offset, blocks[n-1].Offset)
+		}
+		blocks = append(blocks, Block{Offset: offset, Length: length, Checksum: sum})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, errors.New("no blocks")
+	}
+	return blocks, nil
+}
+
+// blockAt returns the block starting at start with the given length, if
+// there is one
+func blockAt(blocks []Block, start, length uint64) (Block, bool) {
+	i := sort.Search(len(blocks), func(i int) bool { return blocks[i].Offset >= start })
+	if i < len(blocks) && blocks[i].Offset == start && blocks[i].Length == length {
+		return blocks[i], true
+	}
+	return Block{}, false
+}
+
+// blockEnd returns where the chunk at start has to end so it doesn't
+// straddle a block boundary, ok is false past the last block
+func blockEnd(blocks []Block, start uint64) (end uint64, ok bool) {
+	i := sort.Search(len(blocks), func(i int) bool { return blocks[i].End() >= start })
+	if i == len(blocks) {
+		return 0, false
+	}
+	if start < blocks[i].Offset {
+		return blocks[i].Offset - 1, true
+	}
+	return blocks[i].End(), true
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// blockManifest lists data in blocks of size bytes
+func blockManifest(data []byte, size int) string {
+	var b strings.Builder
+	for off := 0; off < len(data); off += size {
+		end := min(off+size, len(data))
+		fmt.Fprintf(&b, "%d,%d,%x\n", off, end-off, sha256.Sum256(data[off:end]))
+	}
+	return b.String()
+}
+
+func TestParseBlockManifest(t *testing.T) {
+	sum := fmt.Sprintf("%x", sha256.Sum256(nil))
+	tests := []struct {
+		name     string
+		manifest string
+		want     int
+		err      bool
+	}{
+		{name: "blocks", manifest: "# offset,length,sha256\n0,10," + sum + "\n\n10,5," + sum + "\n", want: 2},
+		{name: "holes", manifest: "0,10," + sum + "\n100,5," + sum + "\n", want: 2},
+		{name: "empty", manifest: "# nothing\n", err: true},
+		{name: "missing field", manifest: "0,10\n", err: true},
+		{name: "zero length", manifest: "0,0," + sum + "\n", err: true},
+		{name: "bad digest", manifest: "0,10,abc\n", err: true},
+		{name: "overlap", manifest: "0,10," + sum + "\n5,10," + sum + "\n", err: true},
+		{name: "out of order", manifest: "10,10," + sum + "\n0,10," + sum + "\n", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks, err := ParseBlockManifest(strings.NewReader(tt.manifest))
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if len(blocks) != tt.want {
+				t.Errorf("got %d blocks, want %d", len(blocks), tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadBlocks(t *testing.T) {
+	data := testData(600000)
+	blocks, err := ParseBlockManifest(strings.NewReader(blockManifest(data, 100000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// How many times the block at 200000 comes back corrupted
+		corrupt int32
+		err     bool
+	}{
+		{name: "intact"},
+		{name: "corrupted once", corrupt: 1},
+		{name: "always corrupted", corrupt: 100, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var left atomic.Int32
+			left.Store(tt.corrupt)
+			bad := append([]byte(nil), data...)
+			bad[250000] ^= 0xff
+			srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.Header.Get("Range"), "bytes=200000-") && left.Add(-1) >= 0 {
+					serveData(bad)(w, r)
+					return
+				}
+				serveData(data)(w, r)
+			}))
+
+			opts := testOptions()
+			opts.Retries = 2
+			opts.Concurrency = 3
+			opts.Blocks = blocks
+			got, err := download(t, srv, opts)
+			var mismatch *ChecksumMismatchError
+			switch {
+			case tt.err:
+				if !errors.As(err, &mismatch) {
+					t.Fatalf("got error %v, want a checksum mismatch", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case string(got) != string(data):
+				t.Error("download doesn't match the served file")
+			}
+		})
+	}
+}
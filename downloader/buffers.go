@@ -0,0 +1,29 @@
+package downloader
+
+import "sync"
+
+// DefaultBufferSize matches what io.Copy allocates for itself
+const DefaultBufferSize = 32 * 1024
+
+// bufferPools holds one *sync.Pool per buffer size, shared by every Download
+var bufferPools sync.Map
+
+func getBuffer(size int) *[]byte {
+	// Only a miss builds a pool, so the common case allocates nothing
+	pool, ok := bufferPools.Load(size)
+	if !ok {
+		pool, _ = bufferPools.LoadOrStore(size, &sync.Pool{
+			New: func() any {
+				buf := make([]byte, size)
+				return &buf
+			},
+		})
+	}
+	return pool.(*sync.Pool).Get().(*[]byte)
+}
+
+func putBuffer(buf *[]byte) {
+	if pool, ok := bufferPools.Load(len(*buf)); ok {
+		pool.(*sync.Pool).Put(buf)
+	}
+}
@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"io"
+	"testing"
+)
+
+// onlyWriter hides io.Discard's ReadFrom so copies go through a buffer
+type onlyWriter struct{ io.Writer }
+
+// onlyReader hides any WriterTo for the same reason
+type onlyReader struct{ io.Reader }
+
+func benchmarkCopy(b *testing.B, copyFn func(dst io.Writer, src io.Reader) (int64, error)) {
+	const size = 256 << 10
+	b.SetBytes(size)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		src := onlyReader{io.LimitReader(zeroReader{}, size)}
+		if _, err := copyFn(onlyWriter{io.Discard}, src); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func BenchmarkIOCopy(b *testing.B) {
+	benchmarkCopy(b, io.Copy)
+}
+
+func BenchmarkCopyBodyPooled(b *testing.B) {
+	benchmarkCopy(b, func(dst io.Writer, src io.Reader) (int64, error) {
+		return copyBody(dst, src, DefaultBufferSize)
+	})
+}
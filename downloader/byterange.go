@@ -0,0 +1,84 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ByteRange is a span of the remote file, both ends inclusive (same as the
+// Range header)
+type ByteRange struct {
+	Start, End uint64
+}
+
+func (r ByteRange) Len() uint64 {
+	return r.End - r.Start + 1
+}
+
+func (r ByteRange) String() string {
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// ParseByteRange parses "start-end", both ends inclusive. Either end may
+// carry a size suffix as understood by ParseSize, e.g. "1M-2M".
+func ParseByteRange(s string) (ByteRange, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return ByteRange{}, fmt.Errorf("invalid range %q, expected start-end", s)
+	}
+	var r ByteRange
+	var err error
+	if r.Start, err = ParseSize(start); err != nil {
+		return ByteRange{}, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+	if r.End, err = ParseSize(end); err != nil {
+		return ByteRange{}, fmt.Errorf("invalid range %q: %w", s, err)
+	}
+	if r.End < r.Start {
+		return ByteRange{}, fmt.Errorf("invalid range %q: end before start", s)
+	}
+	return r, nil
+}
+
+// ParseByteRanges parses a comma separated list of ranges as understood by
+// ParseByteRange, e.g. "0-99,5000-5999"
+func ParseByteRanges(s string) ([]ByteRange, error) {
+	var ranges []ByteRange
+	for _, field := range strings.Split(s, ",") {
+		r, err := ParseByteRange(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// mergeByteRanges returns ranges sorted, with the ones that touch or
+// overlap coalesced
+func mergeByteRanges(ranges []ByteRange) []ByteRange {
+	sorted := append([]ByteRange(nil), ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	var merged []ByteRange
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End+1 {
+			merged[n-1].End = max(merged[n-1].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// shiftedWriterAt moves every write back by shift, so offsets into the
+// remote file land at the same place relative to the start of a range
+type shiftedWriterAt struct {
+	w     io.WriterAt
+	shift int64
+}
+
+func (s *shiftedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	return s.w.WriteAt(p, off-s.shift)
+}
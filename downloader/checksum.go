@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+var hashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+
+	TreeSHA256: NewTreeHash,
+}
+
+// Checksum is an expected digest of a downloaded file
+type Checksum struct {
+	Algo string
+	Sum  []byte
+}
+
+// NewChecksum validates algo and the hex encoded digest
+func NewChecksum(algo, digest string) (*Checksum, error) {
+	algo = strings.ToLower(algo)
+	newHash, ok := hashes[algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	sum, err := hex.DecodeString(strings.TrimSpace(digest))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s digest: %w", algo, err)
+	}
+	if len(sum) != newHash().Size() {
+		return nil, fmt.Errorf("invalid %s digest: expected %d bytes, got %d", algo, newHash().Size(), len(sum))
+	}
+	return &Checksum{Algo: algo, Sum: sum}, nil
+}
+
+// ParseChecksum parses the "algo:hex" form, e.g. "sha256:e3b0c442..."
+func ParseChecksum(s string) (*Checksum, error) {
+	algo, digest, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid checksum %q, expected algo:hex", s)
+	}
+	return NewChecksum(algo, digest)
+}
+
+func (c *Checksum) String() string {
+	return c.Algo + ":" + hex.EncodeToString(c.Sum)
+}
+
+type ChecksumMismatchError struct {
+	Expected *Checksum
+	Got      []byte
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("%s mismatch: expected %x, got %x", e.Expected.Algo, e.Expected.Sum, e.Got)
+}
+
+// VerifyFile streams the file at path through the checksum's hash and
+// returns a *ChecksumMismatchError if the digests differ
+func VerifyFile(path string, c *Checksum) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h, err := c.newHash()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, file); err != nil {
+		return err
+	}
+	return c.check(h.Sum(nil))
+}
+
+func (c *Checksum) newHash() (hash.Hash, error) {
+	newHash, ok := hashes[c.Algo]
+	if !ok {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", c.Algo)
+	}
+	return newHash(), nil
+}
+
+func (c *Checksum) check(got []byte) error {
+	if !bytes.Equal(got, c.Sum) {
+		return &ChecksumMismatchError{Expected: c, Got: got}
+	}
+	return nil
+}
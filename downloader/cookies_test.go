@@ -0,0 +1,91 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const cookieFile = "# Netscape HTTP Cookie File\n" +
+	"\n" +
+	".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc\n" +
+	"only.example.org\tFALSE\t/files\tTRUE\t4102444800\ttoken\txyz\n" +
+	"#HttpOnly_example.net\tFALSE\t/\tFALSE\t0\thidden\t1\n" +
+	"expired.example.com\tFALSE\t/\tFALSE\t1\told\tgone\n"
+
+func TestLoadCookies(t *testing.T) {
+	jar, _ := cookiejar.New(nil)
+	if err := LoadCookies(jar, strings.NewReader(cookieFile)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://example.com/", "session=abc"},
+		{"http://cdn.example.com/a", "session=abc"},
+		{"https://only.example.org/files/x", "token=xyz"},
+		// Not secure, outside the path, a subdomain of a host-only cookie
+		{"http://only.example.org/files/x", ""},
+		{"https://only.example.org/other", ""},
+		{"https://sub.only.example.org/files/x", ""},
+		{"http://example.net/", "hidden=1"},
+		{"http://expired.example.com/", "session=abc"},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		var got []string
+		for _, c := range jar.Cookies(u) {
+			got = append(got, c.Name+"="+c.Value)
+		}
+		if strings.Join(got, "; ") != tt.want {
+			t.Errorf("cookies for %s = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestLoadCookiesErrors(t *testing.T) {
+	for _, file := range []string{
+		"example.com\tTRUE\t/\tFALSE\t0\tname\n",
+		"example.com\tTRUE\t/\tFALSE\tnever\tname\tvalue\n",
+	} {
+		jar, _ := cookiejar.New(nil)
+		if err := LoadCookies(jar, strings.NewReader(file)); err == nil {
+			t.Errorf("LoadCookies(%q) succeeded", file)
+		}
+	}
+}
+
+func TestDownloadWithCookies(t *testing.T) {
+	data := testData(200000)
+	file := serveData(data)
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The login page hands out the session and sends the client on
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok", Path: "/"})
+			http.Redirect(w, r, "/file.bin", http.StatusFound)
+			return
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "ok" {
+			http.Error(w, "log in first", http.StatusForbidden)
+			return
+		}
+		file(w, r)
+	}))
+
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	opts.Jar, _ = cookiejar.New(nil)
+	var sink MemorySink
+	d := &Downloader{Client: srv.Client()}
+	if err := d.DownloadAt(context.Background(), srv.URL+"/login", &sink, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sink.Bytes(), data) {
+		t.Error("download doesn't match the served file")
+	}
+}
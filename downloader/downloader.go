@@ -0,0 +1,1041 @@
+// Package downloader fetches files over HTTP and FTP using concurrent ranged
+// requests.
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Version of the package, sent in the default User-Agent
+const Version = "0.1.0"
+
+// DefaultUserAgent is sent when Options.UserAgent is empty
+const DefaultUserAgent = "downloader/" + Version
+
+// MaxConcurrency caps Options.Concurrency
+const MaxConcurrency = 256
+
+const (
+	DefaultConcurrency    = 10
+	DefaultRetries        = 5
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+	DefaultMaxRetryDelay  = 30 * time.Second
+	DefaultMaxRedirects   = 10
+)
+
+var (
+	// ErrUnknownSize is returned by GetFileSize when the server doesn't
+	// advertise a Content-Length, e.g. for chunked responses
+	ErrUnknownSize = errors.New("Content-Length not found")
+
+	// ErrExists is returned by Download when dest exists and neither
+	// Override nor Overwrite allow replacing it, or NoClobber is set
+	ErrExists = errors.New("file exists")
+
+	// ErrUpToDate is returned by Download when dest already holds the whole
+	// remote file, so there was nothing to fetch
+	ErrUpToDate = errors.New("already up to date")
+
+	// ErrCantResume is returned by Download with MustResume when dest isn't
+	// a partial download of the remote file
+	ErrCantResume = errors.New("can't resume")
+)
+
+// Options control a single Download. Zero values fall back to the defaults,
+// except for Retries where zero disables retrying.
+type Options struct {
+	// Number of chunks fetched in parallel
+	Concurrency int
+	// Start with a couple of workers and add or retire them every second
+	// depending on whether that improves the throughput, up to Concurrency
+	Adaptive bool
+	// Fetch the first SequentialFirst chunks one after another before the
+	// other workers start, so the start of the file is written early, e.g.
+	// to begin playing it
+	SequentialFirst int
+	// Cut the file into Concurrency segments instead of chunks, each
+	// streamed by a worker of its own in a single request, for servers that
+	// dislike many ranged requests. A failed segment is fetched again whole.
+	Segments bool
+	// Size of each ranged request in bytes, 0 picks one from the file size
+	// and Concurrency
+	ChunkSize uint64
+	// If set, chunks start and end on multiples of it in dest, with the
+	// chunk size rounded up to match. Has to be a power of two.
+	Align uint64
+	// Overwrite dest if it already exists
+	Override bool
+	// Decides by size whether an existing dest is replaced, overruling
+	// Override unless it is OverwriteDefault
+	Overwrite OverwritePolicy
+	// Return ErrExists if dest exists, before any request when dest is
+	// given and right after the HEAD when it comes from the server
+	NoClobber bool
+	// Trust an existing dest without a sidecar to hold the start of the
+	// remote file, like curl -C -, and only fetch the rest of it. Needs a
+	// server that supports ranges.
+	Continue bool
+	// Fail with ErrCantResume instead of starting over unless dest is a
+	// partial download of a file with the same size and validator, for
+	// picking it up from a new URL once the old one expired
+	MustResume bool
+	// Only download if the remote file is newer than dest, judged by
+	// Last-Modified and dest's mtime, replacing dest if it is. The mtime of
+	// the new file is set to Last-Modified.
+	IfNewer bool
+	// Refuse files bigger than this many bytes, 0 means no limit. Files of
+	// unknown size are cut off once they grow past it.
+	MaxSize uint64
+	// If set, only this span of the remote file is fetched and written to
+	// dest from offset 0. The server has to support ranges.
+	Range *ByteRange
+	// If set, only these spans of the remote file are fetched, each written
+	// at its own offset of a dest as long as the remote file. The bytes in
+	// between are left as holes. Overlapping spans are merged. The server
+	// has to support ranges, and Range and Checksum can't be used with it.
+	Ranges []ByteRange
+	// Directory a relative dest, or the name suggested by the server, is
+	// placed in. Missing directories are created.
+	OutputDir string
+	// Append an extension matching the Content-Type to a suggested name
+	// without one, e.g. .pdf for application/pdf
+	GuessExtension bool
+	// Fail with an *InsufficientSpaceError before writing anything if the
+	// filesystem of dest can't hold the rest of the file
+	CheckSpace bool
+	// Fail with a *SizeMismatchError, deleting the file, if the finished
+	// file isn't as long as the remote one. Cheap next to a Checksum.
+	VerifySize bool
+
+	// Extra URLs serving the same file, all probed at once. A mirror whose
+	// probe fails is left out. The workers start on whichever answered
+	// quickest, and a chunk that keeps failing on one is fetched from the
+	// next.
+	Mirrors []string
+
+	// Caps the connections to a single host when Downloader.Client is nil,
+	// 0 means no limit
+	MaxConnsPerHost int
+	// If set, caps the requests in flight to each host across every
+	// Download sharing it
+	HostLimiter *HostLimiter
+
+	// If set, opens every connection instead of a plain TCP dial, for HTTP
+	// when Downloader.Client is nil and for FTP control and data
+	// connections. See UnixSocketDialer.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Proxy for every request when Downloader.Client is nil, as returned by
+	// ParseProxy. If nil the HTTP_PROXY and HTTPS_PROXY environment
+	// variables are used.
+	Proxy *url.URL
+	// TLS settings for https and ftps when Downloader.Client is nil, see
+	// LoadTLSConfig
+	TLSConfig *tls.Config
+	// Only speak HTTP/1.1 when Downloader.Client is nil, instead of HTTP/2
+	// wherever the server offers it in ALPN. Some servers handle ranges
+	// over HTTP/2 badly.
+	DisableHTTP2 bool
+
+	// Limit for each request including reading its body, 0 means none. A
+	// request that times out is retried like any other failure.
+	Timeout time.Duration
+	// Most redirects followed by one request, DefaultMaxRedirects if 0.
+	// Negative doesn't follow any, so a moved file is an error. Each hop
+	// is logged at LevelDebug. Ignored if the Downloader's Client has its
+	// own CheckRedirect.
+	MaxRedirects int
+
+	// If set, keeps the cookies for the HEAD and every ranged GET,
+	// including any a redirect sets, overruling the Jar of
+	// Downloader.Client. See LoadCookies.
+	Jar http.CookieJar
+
+	// If set, records every HTTP request and response, see HARRecorder
+	HAR *HARRecorder
+
+	// Credentials sent with the HEAD and every ranged GET
+	Auth Auth
+	// Extra headers sent with every request. Auth is applied after them.
+	Header http.Header
+	// Sent with every request, DefaultUserAgent if empty. A User-Agent in
+	// Header wins over it.
+	UserAgent string
+
+	// Size of the pooled buffers response bodies are copied through,
+	// 0 means DefaultBufferSize
+	BufferSize int
+	// If positive, finished chunks of up to this many bytes in total are
+	// held in memory and written by a single goroutine, lowest offset first,
+	// so workers don't wait for a slow disk. Workers pause while it is full.
+	// See DefaultWriteBehind.
+	WriteBehind uint64
+	// If positive, each worker gathers this many bytes before writing them
+	// to the file, flushing at the end of every chunk, so a fast link makes
+	// fewer write syscalls. 0 writes every copied buffer straight away.
+	WriteBufferSize int
+
+	// Number of times a failed chunk is retried
+	Retries int
+	// Delay before the first retry, doubled on every further retry
+	RetryBaseDelay time.Duration
+	// Longest wait before a retry, also for a server's Retry-After.
+	// DefaultMaxRetryDelay if 0.
+	MaxRetryDelay time.Duration
+	// A request slower than StallSpeed bytes per second over StallWindow
+	// (DefaultStallWindow if 0) is cut off and its chunk retried, maybe on
+	// another mirror. 0 disables the check. Keep it well below a
+	// RateLimiter's share per worker.
+	StallSpeed  uint64
+	StallWindow time.Duration
+	// If positive, each worker waits about this long, give or take half of
+	// it, between its chunk requests, however fast they finish. Cancelling
+	// the context cuts the wait short.
+	RequestDelay time.Duration
+	// Digests of pieces of the remote file, see ParseBlockManifest. Chunks
+	// are cut along them and a chunk not matching its block is retried
+	// straight away instead of failing the Checksum at the end. Can't be
+	// combined with Range.
+	Blocks []Block
+
+	// If set, caps the throughput of all workers combined. The same limiter
+	// can be shared by several Downloads, which then get an even share of it
+	// whatever their Concurrency.
+	RateLimiter *rate.Limiter
+
+	// If set, the finished file is verified against it. A mismatching file
+	// is deleted unless KeepOnMismatch is set.
+	Checksum       *Checksum
+	KeepOnMismatch bool
+
+	// Receives the package's messages, nil means the standard logger at
+	// LevelInfo
+	Logger Logger
+
+	// If set, receives a Status as bytes are written and another one for
+	// every finished chunk. The channel is never closed by Download, but
+	// nothing is sent on it anymore once Download returns.
+	Status chan<- Status
+	// If set, called from the workers as bytes are written, at most every
+	// 100ms
+	Progress ProgressFunc
+
+	// If set, called by Download with the path of the file once it is
+	// complete and passed the checksum. Its error is returned by Download.
+	OnComplete func(path string) error
+
+	tracker         *progressTracker
+	ifModifiedSince time.Time
+	// Hashes the chunks of a Download checked against a TreeSHA256
+	treeHash *TreeHasher
+	// Set by Open, chunks are written strictly in order through write-behind
+	ordered bool
+	// See defaultAdaptInterval
+	adaptInterval time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Concurrency <= 0 {
+		o.Concurrency = DefaultConcurrency
+	}
+	o.Concurrency = min(o.Concurrency, MaxConcurrency)
+	if o.RetryBaseDelay == 0 {
+		o.RetryBaseDelay = DefaultRetryBaseDelay
+	}
+	if o.MaxRetryDelay <= 0 {
+		o.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if o.StallWindow <= 0 {
+		o.StallWindow = DefaultStallWindow
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = DefaultBufferSize
+	}
+	o.Logger = o.logger()
+	o.tracker = nil
+	if o.Progress != nil {
+		o.tracker = &progressTracker{fn: o.Progress}
+	}
+	return o
+}
+
+type Status struct {
+	// Bytes written since the previous Status, negative when a failed
+	// attempt's bytes are taken back
+	Downloaded int
+	// Size of the whole file, 0 if unknown
+	Total int
+	// When the bytes were written
+	Time time.Time
+	// Set when the Status is for a finished or failed chunk, whose bytes
+	// were already counted as they arrived
+	Chunk *ChunkStats
+	// Workers started (positive) or finished (negative) since the previous
+	// Status
+	Workers int
+	// Set when Downloaded counts bytes a resumed download already had on
+	// disk rather than ones just received
+	Resumed bool
+}
+
+// ChunkStats describes how one ranged chunk was fetched
+type ChunkStats struct {
+	// Order in which the chunk was handed to a worker
+	Index int
+	// Both ends inclusive
+	Start, End uint64
+	Began      time.Time
+	Duration   time.Duration
+	// URL the chunk finally came from
+	Mirror string
+	// Requests made for the chunk, over 1 if it had to be retried
+	Attempts int
+	// Time spent waiting between the attempts
+	RetryWait time.Duration
+	// Set if the chunk failed for good
+	Err error
+}
+
+// Downloader fetches files over HTTP and FTP using concurrent ranged
+// requests.
+type Downloader struct {
+	// Client is used for every request. If nil, each Download builds its
+	// own around a single transport shared by all workers.
+	Client *http.Client
+}
+
+type worker struct {
+	client  *http.Client
+	limiter *rate.Limiter
+	// Shared by the workers of one download, see limitedWriter
+	limiterTurn *sync.Mutex
+
+	log            Logger
+	retries        int
+	retryBaseDelay time.Duration
+	maxRetryDelay  time.Duration
+	// See Options.StallSpeed
+	stallSpeed  uint64
+	stallWindow time.Duration
+	blocks      []Block
+
+	// Size of buf, 0 for unbuffered writes
+	writeBuffer int
+	buf         *bufio.Writer
+
+	// Index of the mirror this worker currently fetches from
+	mirror int
+	// Requests made so far and the time waited between them, for
+	// ChunkStats
+	attempts  int
+	retryWait time.Duration
+	// Chunks started so far
+	chunks int
+
+	// Reports bytes as they are written, negative counts take back what a
+	// failed attempt wrote
+	progress func(n int)
+}
+
+func newWorker(client *http.Client, plan *Plan, opts Options) *worker {
+	total := int(plan.Size)
+	return &worker{
+		client:         client,
+		limiter:        opts.RateLimiter,
+		limiterTurn:    plan.limiterTurn,
+		log:            opts.Logger,
+		retries:        opts.Retries,
+		retryBaseDelay: opts.RetryBaseDelay,
+		maxRetryDelay:  opts.MaxRetryDelay,
+		stallSpeed:     opts.StallSpeed,
+		stallWindow:    opts.StallWindow,
+		blocks:         opts.Blocks,
+		writeBuffer:    opts.WriteBufferSize,
+		mirror:         plan.fastest,
+		progress: func(n int) {
+			opts.report(Status{Downloaded: n, Total: total})
+		},
+	}
+}
+
+// FileState is what Exists found at a destination
+type FileState int
+
+const (
+	// Nothing there yet, the download can go ahead
+	FileMissing FileState = iota
+	// A file is there and Override allows replacing it
+	FileOverwrite
+	// A file is there and must be kept
+	FileKeep
+)
+
+func (s FileState) String() string {
+	switch s {
+	case FileMissing:
+		return "missing"
+	case FileOverwrite:
+		return "overwrite"
+	case FileKeep:
+		return "keep"
+	}
+	return fmt.Sprintf("FileState(%d)", int(s))
+}
+
+// Exists reports whether name is already taken and, if so, whether override
+// lets a download replace it
+func Exists(name string, override bool) (FileState, error) {
+	_, err := os.Stat(name)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return FileMissing, nil
+	case err != nil:
+		return FileKeep, err
+	case override:
+		return FileOverwrite, nil
+	default:
+		return FileKeep, nil
+	}
+}
+
+// noClobber returns ErrExists if anything is at dest
+func noClobber(dest string, opts Options) error {
+	state, err := Exists(dest, false)
+	if err != nil {
+		return err
+	}
+	if state != FileMissing {
+		opts.logger().Debug("Not touching", dest, "as it exists")
+		return ErrExists
+	}
+	return nil
+}
+
+// checkDest decides whether a download without a sidecar may write dest.
+// A file of the remote's size that also passes the checksum, if one is
+// given, is left alone and reported as up to date. Without a checksum only
+// Override gets such a file downloaded again.
+func checkDest(dest string, plan *Plan, opts Options) error {
+	override, err := opts.Overwrite.allows(dest, plan, opts)
+	if err != nil {
+		return err
+	}
+	state, err := Exists(dest, override)
+	if err != nil {
+		return err
+	}
+	if state == FileMissing {
+		return nil
+	}
+
+	if !plan.Remote.UnknownSize && (opts.Checksum != nil || state == FileKeep) {
+		info, err := os.Stat(dest)
+		if err == nil && info.Mode().IsRegular() && uint64(info.Size()) == plan.Size &&
+			(opts.Checksum == nil || VerifyFile(dest, opts.Checksum) == nil) {
+			opts.logger().Info(dest, "is already up to date")
+			return ErrUpToDate
+		}
+	}
+
+	if state == FileKeep && opts.Overwrite != OverwriteDefault {
+		opts.logger().Info("Keeping", dest, "as the overwrite policy is", opts.Overwrite)
+		return ErrExists
+	}
+	if state == FileKeep {
+		opts.logger().Error("File exists make sure the *override* flag is set to continue")
+		return ErrExists
+	}
+	return nil
+}
+
+// remoteIsNewer compares plan's remote file with what is at plan.Dest. A
+// missing local file or remote date counts as newer.
+func remoteIsNewer(plan *Plan) (exists, newer bool) {
+	if plan.Remote.NotModified {
+		return true, false
+	}
+	info, err := os.Stat(plan.Dest)
+	if err != nil {
+		return false, true
+	}
+	modified, err := http.ParseTime(plan.Remote.LastModified)
+	if err != nil {
+		return true, true
+	}
+	return true, modified.After(info.ModTime())
+}
+
+// Download fetches url into the file dest, or into the name suggested by the
+// server if dest is empty. The data goes into a temporary file next to dest
+// that is only renamed to dest once it is complete and passed the checksum.
+// Chunks already recorded in dest's sidecar from an earlier interrupted run
+// are skipped. Cancelling ctx stops all in-flight requests and keeps the
+// temporary file and sidecar for a later resume.
+func (d *Downloader) Download(ctx context.Context, url, dest string, opts Options) error {
+	opts = opts.withDefaults()
+
+	if opts.NoClobber && dest != "" {
+		if err := noClobber(opts.destPath(dest), opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.Checksum != nil && opts.Checksum.Algo == TreeSHA256 {
+		opts.treeHash = NewTreeHasher()
+		// Chunks covering whole leaves have them hashed on the fly
+		if opts.Align == 0 {
+			opts.Align = TreeHashLeafSize
+		}
+	}
+
+	client := d.httpClient(opts)
+	if opts.IfNewer && dest != "" {
+		if info, err := os.Stat(opts.destPath(dest)); err == nil {
+			opts.ifModifiedSince = info.ModTime()
+		}
+	}
+	plan, err := d.plan(ctx, client, append([]string{url}, opts.Mirrors...), dest, opts)
+	if err != nil {
+		return err
+	}
+	if dest == "" {
+		opts.Logger.Info("Saving to", plan.Dest)
+		if opts.NoClobber {
+			if err := noClobber(plan.Dest, opts); err != nil {
+				return err
+			}
+		}
+	}
+	dest = plan.Dest
+
+	if opts.IfNewer {
+		exists, newer := remoteIsNewer(plan)
+		if !newer {
+			opts.Logger.Info(dest, "is not older than the remote file")
+			return ErrUpToDate
+		}
+		opts.Override = opts.Override || exists
+	}
+
+	if err := d.fetchFile(ctx, client, plan, opts); err != nil {
+		return err
+	}
+
+	temp := tempName(dest)
+	if opts.VerifySize && !plan.Remote.UnknownSize {
+		if err := verifySize(temp, plan.Size); err != nil {
+			if rmErr := os.Remove(temp); rmErr != nil {
+				opts.Logger.Error("Error removing", temp, "-", rmErr)
+			}
+			return err
+		}
+	}
+	var verifyErr error
+	if opts.Checksum != nil {
+		if opts.treeHash != nil {
+			verifyErr = opts.treeHash.verify(temp, opts.Checksum)
+		} else {
+			verifyErr = VerifyFile(temp, opts.Checksum)
+		}
+		var mismatch *ChecksumMismatchError
+		switch {
+		case verifyErr == nil:
+		case errors.As(verifyErr, &mismatch) && opts.KeepOnMismatch:
+			// Moved into place anyway and still reported
+		case errors.As(verifyErr, &mismatch):
+			if rmErr := os.Remove(temp); rmErr != nil {
+				opts.Logger.Error("Error removing", temp, "-", rmErr)
+			}
+			return verifyErr
+		default:
+			return verifyErr
+		}
+	}
+	// temp sits in the same directory, so dest appears all at once
+	if err := os.Rename(temp, dest); err != nil {
+		return err
+	}
+	if opts.IfNewer {
+		if modified, err := http.ParseTime(plan.Remote.LastModified); err == nil {
+			if err := os.Chtimes(dest, time.Now(), modified); err != nil {
+				opts.Logger.Error("Error setting the mtime of", dest, "-", err)
+			}
+		}
+	}
+	if verifyErr != nil {
+		return verifyErr
+	}
+	if opts.OnComplete != nil {
+		return opts.OnComplete(dest)
+	}
+	return nil
+}
+
+// running reports a worker starting and returns the func reporting it done
+func (opts Options) running(total uint64) func() {
+	opts.report(Status{Workers: 1, Total: int(total)})
+	return func() {
+		opts.report(Status{Workers: -1, Total: int(total)})
+	}
+}
+
+func (opts Options) report(s Status) {
+	s.Time = time.Now()
+	if opts.tracker != nil {
+		opts.tracker.add(s)
+	}
+	if opts.Status != nil {
+		opts.Status <- s
+	}
+}
+
+// fetchFile downloads plan into tempName(plan.Dest)
+func (d *Downloader) fetchFile(ctx context.Context, client *http.Client, plan *Plan, opts Options) error {
+	dest := plan.Dest
+	temp := tempName(dest)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	if plan.Remote.UnknownSize {
+		opts.Logger.Info("Size unknown, downloading as a single stream")
+		if err := checkDest(dest, plan, opts); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0664)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		// Tells consumers nothing was there before the first write
+		opts.report(Status{})
+		defer opts.running(0)()
+		if opts.MaxSize == 0 {
+			_, err := newWorker(client, plan, opts).fetchRange(ctx, plan, opts, file, 0, -1, false)
+			return err
+		}
+		_, err = newWorker(client, plan, opts).fetchRange(ctx, plan, opts, &cappedWriterAt{w: file, limit: opts.MaxSize}, 0, -1, false)
+		var tooLarge *SizeLimitError
+		if errors.As(err, &tooLarge) {
+			file.Close()
+			if rmErr := os.Remove(temp); rmErr != nil {
+				opts.Logger.Error("Error removing", temp, "-", rmErr)
+			}
+		}
+		return err
+	}
+
+	// Nothing to fetch, and no chunk ranges to track either
+	if plan.Size == 0 {
+		if err := checkDest(dest, plan, opts); err != nil {
+			return err
+		}
+		file, err := os.Create(temp)
+		if err != nil {
+			return err
+		}
+		opts.report(Status{})
+		return file.Close()
+	}
+
+	state, found, err := loadResumeState(dest, plan, opts.MustResume, opts.Logger)
+	if err != nil {
+		return err
+	}
+
+	if !found && opts.Continue {
+		if !plan.Ranged {
+			opts.Logger.Info("Server does not support ranges, can't continue", dest)
+		} else if found, err = continuePartial(dest, plan, state, opts.Logger); err != nil {
+			return err
+		}
+	}
+
+	// A sidecar means the temporary file is our own partial download
+	flags := os.O_CREATE | os.O_WRONLY
+	if !found {
+		if opts.MustResume {
+			return fmt.Errorf("%w: no partial download of %s", ErrCantResume, dest)
+		}
+		if err := checkDest(dest, plan, opts); err != nil {
+			return err
+		}
+		flags |= os.O_TRUNC
+	} else if info, err := os.Stat(temp); err != nil || uint64(info.Size()) != plan.Size {
+		// The file is preallocated on the first run, so any other size
+		// means it isn't the one the sidecar describes
+		if opts.MustResume {
+			return fmt.Errorf("%w: %s doesn't match %s", ErrCantResume, temp, sidecarName(dest))
+		}
+		if len(state.Done) > 0 {
+			opts.Logger.Info("Existing", temp, "doesn't match", sidecarName(dest), "- starting over")
+		}
+		state.Reset(plan)
+		flags |= os.O_TRUNC
+	} else if len(state.Done) > 0 {
+		opts.Logger.Info("Resuming download,", state.Downloaded(), "bytes already present")
+	}
+	state.Skip(plan)
+	if opts.CheckSpace {
+		if err := checkSpace(temp, plan.Size-state.Downloaded(), opts.Logger); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(temp, flags, 0664)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for restarted := false; ; restarted = true {
+		if err := file.Truncate(int64(plan.Size)); err != nil {
+			return err
+		}
+		// Written up front so even a run interrupted before its first
+		// chunk completes can be resumed
+		if err := state.Save(); err != nil {
+			return err
+		}
+		// Lets consumers learn the total before the first chunk lands
+		opts.report(Status{Downloaded: int(state.Downloaded()), Total: int(plan.Size), Resumed: true})
+
+		var target io.WriterAt = file
+		if opts.treeHash != nil {
+			target = opts.treeHash.Tee(file)
+		}
+		err := d.fetchChunks(ctx, client, plan, opts, state, target)
+		if ctx.Err() != nil {
+			keepPartial(file, state, opts.Logger)
+			return err
+		}
+		if !errors.Is(err, errResourceChanged) || restarted {
+			if err != nil {
+				return err
+			}
+			break
+		}
+
+		if opts.MustResume {
+			return fmt.Errorf("%w: remote file changed during the download", ErrCantResume)
+		}
+		opts.Logger.Info("Remote file changed during the download, starting over")
+		opts.report(Status{Downloaded: -int(state.Downloaded()), Total: int(plan.Size)})
+		if plan, err = d.plan(ctx, client, plan.URLs, dest, opts); err != nil {
+			return err
+		}
+		if plan.Remote.UnknownSize {
+			return errResourceChanged
+		}
+		state.Reset(plan)
+	}
+
+	size := plan.Size
+	if downloaded := state.Downloaded(); downloaded != size {
+		return &IncompleteError{Downloaded: downloaded, Size: size}
+	}
+	// The file may have turned out shorter than announced
+	if err := file.Truncate(int64(size)); err != nil {
+		return err
+	}
+	if err := state.Remove(); err != nil {
+		opts.Logger.Error("Error removing", sidecarName(dest), "-", err)
+	}
+	return nil
+}
+
+// keepPartial makes sure the chunks recorded in the sidecar of a stopped
+// download are on disk before the process exits
+func keepPartial(file *os.File, state *resumeState, logger Logger) {
+	if err := file.Sync(); err != nil {
+		logger.Error("Error syncing", file.Name(), "-", err)
+	}
+	if err := state.Save(); err != nil {
+		logger.Error("Error saving progress: ", err)
+	}
+}
+
+// continuePartial turns dest into the temporary file of a resumed download,
+// taking everything in it as the first bytes of the remote file. It reports
+// whether there was a dest to continue.
+func continuePartial(dest string, plan *Plan, state *resumeState, logger Logger) (bool, error) {
+	info, err := os.Stat(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	size := uint64(info.Size())
+	switch {
+	case !info.Mode().IsRegular():
+		return false, fmt.Errorf("can't continue %s, not a regular file", dest)
+	case size == plan.Size:
+		logger.Info(dest, "is already complete")
+		return false, ErrUpToDate
+	case size > plan.Size:
+		return false, fmt.Errorf("can't continue %s, it is %d bytes and the remote file only %d", dest, size, plan.Size)
+	}
+
+	temp := tempName(dest)
+	if err := os.Rename(dest, temp); err != nil {
+		return false, err
+	}
+	// The size check on resume expects the preallocated length
+	if err := os.Truncate(temp, int64(plan.Size)); err != nil {
+		return false, err
+	}
+	if size > 0 {
+		if err := state.MarkDone(0, size-1); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// fetchChunks downloads every chunk of plan not yet recorded in state into
+// file, or the whole file in a single stream if the server can't do ranges.
+// If the last chunk comes back short the server announced the wrong size,
+// and plan.Size and state are cut down to what actually arrived.
+func (d *Downloader) fetchChunks(ctx context.Context, client *http.Client, plan *Plan, opts Options, state *resumeState, file io.WriterAt) error {
+	size := plan.Size
+	ranged := plan.Ranged
+	if !ranged {
+		opts.Logger.Info("Server does not support ranges, downloading as a single stream")
+		if len(opts.Blocks) > 0 {
+			opts.Logger.Info("Blocks can't be checked in a single stream")
+		}
+	}
+
+	queue := newChunkQueue(state.Missing(), plan.ChunkSize, plan.Align, plan.Workers)
+	queue.blocks = opts.Blocks
+	if opts.Segments {
+		queue.segment(plan.Workers)
+	}
+	var wb *writeBehind
+	if ranged && (opts.WriteBehind > 0 || opts.ordered) {
+		limit := opts.WriteBehind
+		if limit == 0 {
+			limit = DefaultWriteBehind
+		}
+		wb = newWriteBehind(file, limit, opts.ordered)
+	}
+	var wg sync.WaitGroup
+	var rangeIgnored, changed atomic.Bool
+	var failures failureTracker
+	var shortened atomic.Bool
+	var realSize atomic.Uint64
+	shrink := func(actual uint64) {
+		opts.Logger.Info("File ends after", actual, "bytes instead of the announced", size)
+		plan.Size = actual
+		state.Shrink(actual)
+		opts.report(Status{Total: int(actual)})
+	}
+
+	var adapt *adaptiveWorkers
+	if ranged && opts.Adaptive {
+		adapt = newAdaptiveWorkers(plan.Workers)
+	}
+	var live atomic.Int32
+	// A worker with a limit stops after that many chunks
+	spawn := func(limit int) {
+		w := newWorker(client, plan, opts)
+		if adapt != nil {
+			w.progress = adapt.counting(w.progress)
+		}
+		wg.Add(1)
+		live.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			defer live.Add(-1)
+			defer opts.running(size)()
+			for !rangeIgnored.Load() && !changed.Load() && ctx.Err() == nil {
+				if adapt != nil && adapt.retired() || limit > 0 && w.chunks == limit {
+					return
+				}
+				// NOTE: Range is inclusive
+				index, start, end, ok := queue.next()
+				if !ok {
+					return
+				}
+				if w.chunks > 0 && opts.RequestDelay > 0 {
+					if sleep(ctx, jitter(opts.RequestDelay)) != nil {
+						return
+					}
+				}
+				w.chunks++
+
+				began := time.Now()
+				w.attempts, w.retryWait = 0, 0
+				target := file
+				var buf *chunkBuffer
+				if wb != nil {
+					var err error
+					if buf, err = wb.reserve(ctx, start, end-start+1, int(plan.ChunkSize)); err != nil {
+						return
+					}
+					target = buf
+				}
+				n, err := w.fetchRange(ctx, plan, opts, target, int64(start), int64(end-start+1), true)
+				if buf != nil && (err != nil || n == 0) {
+					wb.release(buf)
+				}
+				if errors.Is(err, errRangeIgnored) {
+					rangeIgnored.Store(true)
+					return
+				}
+				if errors.Is(err, errResourceChanged) {
+					changed.Store(true)
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					opts.Logger.Error("Error Downloading: ", err)
+					failures.add(start, end, err)
+					if wb != nil && wb.ordered {
+						wb.fail(err)
+					}
+					opts.report(Status{Total: int(size), Chunk: &ChunkStats{
+						Index:     index,
+						Start:     start,
+						End:       end,
+						Began:     began,
+						Duration:  time.Since(began),
+						Mirror:    plan.URLs[w.mirror],
+						Attempts:  w.attempts,
+						RetryWait: w.retryWait,
+						Err:       err,
+					}})
+					continue
+				}
+				// Only the chunk at the end of the file may be short
+				if uint64(n) < end-start+1 {
+					shortened.Store(true)
+					realSize.Store(start + uint64(n))
+					if n == 0 {
+						continue
+					}
+					end = start + uint64(n) - 1
+				}
+				stats := &ChunkStats{
+					Index:     index,
+					Start:     start,
+					End:       end,
+					Began:     began,
+					Duration:  time.Since(began),
+					Mirror:    plan.URLs[w.mirror],
+					Attempts:  w.attempts,
+					RetryWait: w.retryWait,
+				}
+				done := func() {
+					opts.Logger.Debug("Finished bytes", stats.Start, "-", stats.End)
+					if err := state.MarkDone(stats.Start, stats.End); err != nil {
+						opts.Logger.Error("Error saving progress: ", err)
+					}
+					opts.report(Status{Total: int(size), Chunk: stats})
+				}
+				if buf != nil {
+					wb.submit(buf, n, done)
+				} else {
+					done()
+				}
+			}
+		}(w)
+	}
+	if ranged && opts.SequentialFirst > 0 {
+		spawn(opts.SequentialFirst)
+		wg.Wait()
+	}
+	workers := plan.Workers
+	if adapt != nil {
+		workers = adapt.target
+	}
+	for i := 0; ranged && i < workers; i++ {
+		spawn(0)
+	}
+	if adapt != nil {
+		interval := opts.adaptInterval
+		if interval <= 0 {
+			interval = defaultAdaptInterval
+		}
+		// Workers also stop early when the download can't go on
+		done := func() bool { return live.Load() == 0 || queue.empty() }
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			adapt.run(ctx, interval, func() { spawn(0) }, done, opts.Logger)
+		}()
+	}
+
+	wg.Wait()
+	// Chunks still in memory are written even when stopping, so they can be
+	// recorded in the sidecar
+	if wb != nil {
+		if err := wb.close(); err != nil {
+			return err
+		}
+	}
+
+	// Everything written so far is already recorded in the sidecar, so a
+	// later run picks up from here
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if changed.Load() {
+		return errResourceChanged
+	}
+	if failed := failures.ranges(); len(failed) > 0 {
+		return &IncompleteError{Failed: failed, Downloaded: state.Downloaded(), Size: size}
+	}
+	if shortened.Load() {
+		shrink(realSize.Load())
+	}
+
+	if !ranged || rangeIgnored.Load() {
+		// The whole body would land where only the range belongs
+		if plan.Range != nil {
+			return fmt.Errorf("%w, can't fetch only bytes %s", errRangeIgnored, plan.Range)
+		}
+		if rangeIgnored.Load() {
+			opts.Logger.Info("Server ignored the range request, downloading as a single stream")
+		}
+		// The stream counts every byte again
+		if done := state.Downloaded(); done > 0 {
+			opts.report(Status{Downloaded: -int(done), Total: int(size)})
+		}
+		done := opts.running(size)
+		w := newWorker(client, plan, opts)
+		n, err := w.fetchRange(ctx, plan, opts, file, 0, int64(size), false)
+		done()
+		if err != nil {
+			return err
+		}
+		if uint64(n) < size {
+			shrink(uint64(n))
+		}
+		if n > 0 {
+			if err := state.MarkDone(0, uint64(n)-1); err != nil {
+				opts.Logger.Error("Error saving progress: ", err)
+			}
+		}
+	}
+	return nil
+}
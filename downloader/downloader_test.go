@@ -0,0 +1,561 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadConcurrentMatchesSingle(t *testing.T) {
+	data := testData(1<<20 + 12345)
+	srv := newServer(t, serveData(data))
+
+	single := testOptions()
+	single.Concurrency = 1
+	want, err := download(t, srv, single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, data) {
+		t.Fatal("single download doesn't match the served file")
+	}
+
+	concurrent := testOptions()
+	concurrent.Concurrency = 8
+	concurrent.ChunkSize = 64 << 10
+	got, err := download(t, srv, concurrent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("concurrent download differs from the single one")
+	}
+}
+
+func TestDownloadWithoutRanges(t *testing.T) {
+	data := testData(300000)
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("Range")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}))
+
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("single stream doesn't match the served file")
+	}
+}
+
+func TestDownloadServerErrorWritesNothing(t *testing.T) {
+	data := testData(200000)
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			serveData(data)(w, r)
+			return
+		}
+		http.Error(w, strings.Repeat("error page ", 100), http.StatusInternalServerError)
+	}))
+
+	d := &Downloader{Client: srv.Client()}
+	var sink MemorySink
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	err := d.DownloadAt(context.Background(), srv.URL+"/file.bin", &sink, opts)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusInternalServerError {
+		t.Fatalf("got error %v, want status 500", err)
+	}
+	for i, b := range sink.Bytes() {
+		if b != 0 {
+			t.Fatalf("byte %d was written", i)
+		}
+	}
+}
+
+func TestDownloadShortBody(t *testing.T) {
+	data := testData(200000)
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil || end == len(data)-1 {
+			serveData(data)(w, r)
+			return
+		}
+		// Half of what was asked for, with a matching Content-Length
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[start : start+(end-start+1)/2])
+	}))
+
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	got, err := download(t, srv, opts)
+	var short *shortChunkError
+	if !errors.As(err, &short) {
+		t.Fatalf("got error %v, want a short chunk", err)
+	}
+	if got != nil {
+		t.Error("incomplete download was moved into place")
+	}
+}
+
+func TestDownloadTruncatedBody(t *testing.T) {
+	data := testData(200000)
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			serveData(data)(w, r)
+			return
+		}
+		// Announces the whole range, then hangs up halfway
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data[:len(data)/2])
+	}))
+
+	opts := testOptions()
+	opts.Concurrency = 1
+	_, err := download(t, srv, opts)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got error %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDownloadLongBody(t *testing.T) {
+	data := testData(200000)
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Announces 1000 bytes without ranges, then sends far more
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", "1000")
+			return
+		}
+		w.Write(data)
+	}))
+
+	status := make(chan Status)
+	downloaded := make(chan int)
+	go func() {
+		var n, most int
+		for s := range status {
+			n += s.Downloaded
+			most = max(most, n)
+		}
+		downloaded <- most
+	}()
+	opts := testOptions()
+	opts.Status = status
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	d := &Downloader{Client: srv.Client()}
+	err := d.Download(context.Background(), srv.URL+"/file.bin", dest, opts)
+	close(status)
+	var long *longChunkError
+	if !errors.As(err, &long) {
+		t.Fatalf("got error %v, want a long chunk", err)
+	}
+	if most := <-downloaded; most > 1000 {
+		t.Errorf("progress reached %d bytes, want at most 1000", most)
+	}
+	if info, err := os.Stat(tempName(dest)); err == nil && info.Size() > 1000 {
+		t.Errorf("wrote %d bytes, want at most 1000", info.Size())
+	}
+}
+
+// liar announces the size of data but only serves its first len(data)-short
+// bytes
+func liar(data []byte, short int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			return
+		}
+		serveData(data[:len(data)-short])(w, r)
+	}
+}
+
+func TestDownloadContentLengthLies(t *testing.T) {
+	data := testData(1 << 20)
+	tests := []struct {
+		name  string
+		short int
+	}{
+		// The last chunk comes back short
+		{name: "within the last chunk", short: 300},
+		// The last chunk starts right where the file ends and gets a 416
+		{name: "at a chunk boundary", short: 64 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t, liar(data, tt.short))
+			opts := testOptions()
+			opts.ChunkSize = 64 << 10
+			// Checks against the size that arrived, not the announced one
+			opts.VerifySize = true
+			got, err := download(t, srv, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data[:len(data)-tt.short]) {
+				t.Errorf("got %d bytes, want the %d the server had", len(got), len(data)-tt.short)
+			}
+		})
+	}
+
+	t.Run("before the last chunk", func(t *testing.T) {
+		srv := newServer(t, liar(data, 300<<10))
+		opts := testOptions()
+		opts.ChunkSize = 64 << 10
+		_, err := download(t, srv, opts)
+		if !errors.Is(err, ErrRangeNotSatisfiable) {
+			t.Fatalf("got error %v, want %v", err, ErrRangeNotSatisfiable)
+		}
+	})
+}
+
+func TestDownloadRange(t *testing.T) {
+	data := testData(500000)
+	srv := newServer(t, serveData(data))
+
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	opts.Range = &ByteRange{Start: 1000, End: 299999}
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[1000:300000]) {
+		t.Error("range doesn't match the served bytes")
+	}
+}
+
+// countingResponse counts the body bytes written to it
+type countingResponse struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingResponse) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestDownloadRanges(t *testing.T) {
+	data := testData(500000)
+	tests := []struct {
+		name   string
+		ranges []ByteRange
+	}{
+		{name: "one", ranges: []ByteRange{{1000, 1999}}},
+		{name: "scattered", ranges: []ByteRange{{400000, 499999}, {0, 99}, {70000, 200000}}},
+		{name: "overlapping", ranges: []ByteRange{{100, 300000}, {200000, 350000}}},
+		{name: "everything", ranges: []ByteRange{{0, 499999}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetched atomic.Int64
+			srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				cw := &countingResponse{ResponseWriter: w}
+				serveData(data)(cw, r)
+				if r.Method == http.MethodGet {
+					fetched.Add(cw.n)
+				}
+			}))
+
+			opts := testOptions()
+			opts.ChunkSize = 64 << 10
+			opts.Ranges = tt.ranges
+			got, err := download(t, srv, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]byte, len(data))
+			var size int64
+			for _, r := range mergeByteRanges(tt.ranges) {
+				copy(want[r.Start:r.End+1], data[r.Start:r.End+1])
+				size += int64(r.Len())
+			}
+			if !bytes.Equal(got, want) {
+				t.Error("file doesn't hold the ranges at their offsets with zeros in between")
+			}
+			// Probing the size may read a byte more
+			if n := fetched.Load(); n < size || n > size+1 {
+				t.Errorf("fetched %d bytes, want %d", n, size)
+			}
+		})
+	}
+}
+
+func TestDownloadRangesOutside(t *testing.T) {
+	srv := newServer(t, serveData(testData(1000)))
+	opts := testOptions()
+	opts.Ranges = []ByteRange{{0, 99}, {900, 1000}}
+	if _, err := download(t, srv, opts); err == nil {
+		t.Error("range past the end of the file was accepted")
+	}
+}
+
+func TestDownloadSegments(t *testing.T) {
+	data := testData(5<<20 + 3)
+	var mu sync.Mutex
+	var ranges []string
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		serveData(data)(w, r)
+	}))
+
+	opts := testOptions()
+	opts.Concurrency = 3
+	opts.Segments = true
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+	slices.Sort(ranges)
+	want := []string{"bytes=0-1747627", "bytes=1747628-3495255", "bytes=3495256-5242882"}
+	if !slices.Equal(ranges, want) {
+		t.Errorf("requested %q, want %q", ranges, want)
+	}
+}
+
+func TestDownloadSequentialFirst(t *testing.T) {
+	data := testData(1 << 20)
+	type request struct {
+		rangeHeader string
+		active      int32
+	}
+	var mu sync.Mutex
+	var requests []request
+	var active atomic.Int32
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			n := active.Add(1)
+			defer active.Add(-1)
+			mu.Lock()
+			requests = append(requests, request{r.Header.Get("Range"), n})
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+		}
+		serveData(data)(w, r)
+	}))
+
+	opts := testOptions()
+	opts.Concurrency = 4
+	opts.ChunkSize = 64 << 10
+	opts.SequentialFirst = 3
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+	want := []request{{"bytes=0-65535", 1}, {"bytes=65536-131071", 1}, {"bytes=131072-196607", 1}}
+	if len(requests) < len(want) || !slices.Equal(requests[:len(want)], want) {
+		t.Fatalf("first requests were %v, want %v", requests[:min(len(requests), len(want))], want)
+	}
+	var parallel bool
+	for _, r := range requests[len(want):] {
+		parallel = parallel || r.active > 1
+	}
+	if !parallel {
+		t.Error("the rest of the file wasn't fetched in parallel")
+	}
+}
+
+func TestVerifySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySize(path, 100); err != nil {
+		t.Errorf("matching size: %v", err)
+	}
+	var mismatch *SizeMismatchError
+	if err := verifySize(path, 150); !errors.As(err, &mismatch) || mismatch.Expected != 150 || mismatch.Actual != 100 {
+		t.Errorf("got error %v, want 150 expected and 100 actual", err)
+	}
+	if err := verifySize(path+".missing", 100); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v for a missing file", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		override bool
+		want     FileState
+		err      bool
+	}{
+		{name: "missing", path: filepath.Join(dir, "missing"), want: FileMissing},
+		{name: "missing with override", path: filepath.Join(dir, "missing"), override: true, want: FileMissing},
+		{name: "exists", path: file, want: FileKeep},
+		{name: "exists with override", path: file, override: true, want: FileOverwrite},
+		// A file in place of a directory is neither missing nor there
+		{name: "stat error", path: filepath.Join(file, "child"), want: FileKeep, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Exists(tt.path, tt.override)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadNoClobber(t *testing.T) {
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected %s request", r.Method)
+	}))
+
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(dest, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := &Downloader{Client: srv.Client()}
+	opts := testOptions()
+	opts.NoClobber = true
+	if err := d.Download(context.Background(), srv.URL+"/file.bin", dest, opts); !errors.Is(err, ErrExists) {
+		t.Fatalf("got error %v, want %v", err, ErrExists)
+	}
+	if data, _ := os.ReadFile(dest); string(data) != "old" {
+		t.Errorf("file was changed to %q", data)
+	}
+}
+
+func BenchmarkDownload(b *testing.B) {
+	data := testData(32 << 20)
+	srv := newServer(b, serveData(data))
+	for _, bench := range []struct {
+		name        string
+		writeBuffer int
+	}{
+		{"unbuffered", 0},
+		{"write buffer 1M", 1 << 20},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			opts := testOptions()
+			opts.ChunkSize = 1 << 20
+			// Small copy buffers make for many writes, as on a fast link
+			opts.BufferSize = 4 << 10
+			opts.WriteBufferSize = bench.writeBuffer
+			opts.Override = true
+			dest := filepath.Join(b.TempDir(), "file.bin")
+			d := &Downloader{Client: srv.Client()}
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := d.Download(context.Background(), srv.URL+"/file.bin", dest, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadMustResume(t *testing.T) {
+	data := testData(1 << 20)
+	modTime := time.Unix(1700000000, 0)
+	half := uint64(len(data) / 2)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		partial bool
+		wantErr bool
+	}{
+		{name: "same file", handler: serveData(data), partial: true},
+		{
+			name: "changed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "file.bin", modTime.Add(time.Hour), bytes.NewReader(data))
+			},
+			partial: true,
+			wantErr: true,
+		},
+		{name: "other size", handler: serveData(data[:1000]), partial: true, wantErr: true},
+		{name: "nothing to resume", handler: serveData(data), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fromStart atomic.Bool
+			srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet && strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+					fromStart.Store(true)
+				}
+				tt.handler(w, r)
+			}))
+			dest := filepath.Join(t.TempDir(), "file.bin")
+			if tt.partial {
+				// As left behind by an interrupted run from another URL
+				partial := append(bytes.Clone(data[:half]), make([]byte, len(data)-int(half))...)
+				if err := os.WriteFile(tempName(dest), partial, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				remote := &RemoteFile{Size: uint64(len(data)), LastModified: modTime.UTC().Format(http.TimeFormat)}
+				state := newResumeState(dest, &Plan{Remote: remote, Size: remote.Size})
+				if err := state.MarkDone(0, half-1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			opts := testOptions()
+			opts.MustResume = true
+			d := &Downloader{Client: srv.Client()}
+			err := d.Download(context.Background(), srv.URL+"/fresh.bin?signature=new", dest, opts)
+			if tt.wantErr {
+				if !errors.Is(err, ErrCantResume) {
+					t.Errorf("got error %v, want %v", err, ErrCantResume)
+				}
+				if _, err := os.Stat(dest); err == nil {
+					t.Error("dest was written")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fromStart.Load() {
+				t.Error("fetched the part already on disk")
+			}
+			if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+				t.Error("download doesn't match the served file")
+			}
+		})
+	}
+}
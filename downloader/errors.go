@@ -0,0 +1,75 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrRangeNotSatisfiable matches an *HTTPStatusError for a 416 response, i.e.
+// a chunk asked for bytes past the end of the remote file
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// HTTPStatusError is returned for a response other than 200 or 206. Nothing
+// of its body is written.
+type HTTPStatusError struct {
+	Code int
+	// How long a 429 or 503 asked to wait in Retry-After, 0 if it didn't
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.Code, http.StatusText(e.Code))
+}
+
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == ErrRangeNotSatisfiable && e.Code == http.StatusRequestedRangeNotSatisfiable
+}
+
+// statusError is the error for an unexpected resp
+func statusError(resp *http.Response) *HTTPStatusError {
+	err := &HTTPStatusError{Code: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return err
+}
+
+// parseRetryAfter reads a Retry-After of either delay seconds or an HTTP
+// date. A date already past or a value that is neither gives 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// SizeMismatchError is returned by Download with Options.VerifySize when
+// the finished file has the wrong length
+type SizeMismatchError struct {
+	Expected, Actual uint64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("size mismatch: expected %d bytes, got %d", e.Expected, e.Actual)
+}
+
+// verifySize checks that the file at path is size bytes long
+func verifySize(path string, size uint64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if uint64(info.Size()) != size {
+		return &SizeMismatchError{Expected: size, Actual: uint64(info.Size())}
+	}
+	return nil
+}
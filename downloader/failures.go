@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FailedRange is a chunk that could not be downloaded even after retries
+type FailedRange struct {
+	// Both ends inclusive
+	Start, End uint64
+	Err        error
+}
+
+// IncompleteError is returned by Download when some chunks never made it to
+// disk. The sidecar is kept, so running the download again only fetches
+// what is missing.
+type IncompleteError struct {
+	Failed     []FailedRange
+	Downloaded uint64
+	Size       uint64
+}
+
+func (e *IncompleteError) Error() string {
+	return fmt.Sprintf("download incomplete: %d of %d bytes, %d chunks failed", e.Downloaded, e.Size, len(e.Failed))
+}
+
+// Unwrap exposes the error of every failed chunk to errors.Is and errors.As
+func (e *IncompleteError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// failureTracker collects the chunks workers gave up on
+type failureTracker struct {
+	mu     sync.Mutex
+	failed []FailedRange
+}
+
+func (f *failureTracker) add(start, end uint64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed = append(f.failed, FailedRange{Start: start, End: end, Err: err})
+}
+
+func (f *failureTracker) ranges() []FailedRange {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	failed := append([]FailedRange(nil), f.failed...)
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Start < failed[j].Start })
+	return failed
+}
@@ -0,0 +1,155 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+// ftpProtocol fetches ftp:// and ftps:// (implicit TLS) URLs. Every call
+// logs in on a connection of its own, so workers don't share any state.
+// Ranges are fetched with REST; servers that refuse it make the download
+// fall back to a single stream.
+type ftpProtocol struct {
+	opts Options
+}
+
+func (p *ftpProtocol) dial(ctx context.Context, rawURL string) (*ftp.ServerConn, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "21"
+		if u.Scheme == "ftps" {
+			port = "990"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	options := []ftp.DialOption{ftp.DialWithContext(ctx)}
+	if p.opts.Timeout > 0 {
+		options = append(options, ftp.DialWithTimeout(p.opts.Timeout))
+	}
+	var config *tls.Config
+	if u.Scheme == "ftps" {
+		config = &tls.Config{}
+		if p.opts.TLSConfig != nil {
+			config = p.opts.TLSConfig.Clone()
+		}
+		config.ServerName = u.Hostname()
+		options = append(options, ftp.DialWithTLS(config))
+	}
+	if dial := p.opts.DialContext; dial != nil {
+		// The library skips its own TLS handshake for a custom dial func
+		options = append(options, ftp.DialWithDialFunc(func(network, address string) (net.Conn, error) {
+			conn, err := dial(ctx, network, address)
+			if err != nil || config == nil {
+				return conn, err
+			}
+			return tls.Client(conn, config), nil
+		}))
+	}
+	conn, err := ftp.Dial(addr, options...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Credentials in the URL win over Auth, with anonymous as the fallback
+	user, password := "anonymous", "anonymous"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	} else if p.opts.Auth.Username != "" {
+		user, password = p.opts.Auth.Username, p.opts.Auth.Password
+	}
+	if err := conn.Login(user, password); err != nil {
+		conn.Quit()
+		return nil, "", err
+	}
+	return conn, u.Path, nil
+}
+
+func (p *ftpProtocol) stat(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	conn, path, err := p.dial(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Quit()
+
+	size, err := conn.FileSize(path)
+	if err != nil {
+		return nil, err
+	}
+	// Whether REST works only shows once a chunk asks for it
+	remote := &RemoteFile{URL: rawURL, Size: uint64(size), AcceptRanges: true}
+	if conn.IsGetTimeSupported() {
+		if modified, err := conn.GetTime(path); err == nil {
+			remote.LastModified = modified.UTC().Format(http.TimeFormat)
+		}
+	}
+	return remote, nil
+}
+
+func (p *ftpProtocol) get(ctx context.Context, r rangeRequest, dst io.Writer) (int64, error) {
+	conn, path, err := p.dial(ctx, r.url)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Quit()
+
+	var resp *ftp.Response
+	if r.ranged {
+		resp, err = conn.RetrFrom(path, uint64(r.start))
+	} else {
+		resp, err = conn.Retr(path)
+	}
+	if err != nil {
+		if r.ranged && r.start > 0 && restRefused(err) {
+			return 0, fmt.Errorf("%w: %v", errRangeIgnored, err)
+		}
+		return 0, err
+	}
+	// The data connection doesn't know about ctx
+	stop := context.AfterFunc(ctx, func() { resp.SetDeadline(time.Now()) })
+	defer stop()
+
+	var src io.Reader = resp
+	if r.ranged {
+		src = io.LimitReader(resp, r.length)
+	}
+	n, err := copyBody(dst, src, p.opts.BufferSize)
+	if ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+	// Closing a ranged transfer early aborts it, so the server's complaint
+	// about that doesn't count
+	if closeErr := resp.Close(); err == nil && !r.ranged {
+		err = closeErr
+	}
+	return n, err
+}
+
+// restRefused tells whether err is the server rejecting REST as an unknown
+// or unimplemented command
+func restRefused(err error) bool {
+	var ftpErr *textproto.Error
+	if !errors.As(err, &ftpErr) {
+		return false
+	}
+	switch ftpErr.Code {
+	case ftp.StatusCommandNotImplemented, ftp.StatusBadCommand, ftp.StatusBadArguments, ftp.StatusNotImplemented, ftp.StatusNotImplementedParameter:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,331 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HARRecorder keeps every HTTP request and response of the Downloads it is
+// given to as Options.HAR, for WriteTo to save as a HAR 1.2 file. It is
+// safe for concurrent use. Credentials and cookies are left out.
+type HARRecorder struct {
+	// Keep up to this many bytes of each response body, 0 keeps none
+	BodyLimit int
+
+	mu      sync.Mutex
+	entries []*harEntry
+}
+
+// NewHARRecorder returns an empty HARRecorder keeping bodyLimit bytes of
+// each response body
+func NewHARRecorder(bodyLimit int) *HARRecorder {
+	return &HARRecorder{BodyLimit: bodyLimit}
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	QueryString []harHeader `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []harHeader `json:"cookies"`
+	Headers     []harHeader `json:"headers"`
+	Content     harContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harTimings are in milliseconds, -1 for the phases a request skipped such
+// as dialing on a reused connection
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	SSL     float64 `json:"ssl"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// WriteTo writes everything recorded so far as a HAR file, entries in the
+// order the requests started
+func (h *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	entries := append([]*harEntry(nil), h.entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartedDateTime.Before(entries[j].StartedDateTime) })
+	data, err := json.MarshalIndent(map[string]harLog{"log": {
+		Version: "1.2",
+		Creator: harCreator{Name: "downloader", Version: Version},
+		Entries: entries,
+	}}, "", "  ")
+	h.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(append(data, '\n'))
+	return int64(n), err
+}
+
+// transport wraps base so every round trip is recorded
+func (h *HARRecorder) transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &harTransport{base: base, har: h}
+}
+
+type harTransport struct {
+	base http.RoundTripper
+	har  *HARRecorder
+}
+
+// harTrace collects when each phase of a request ended
+type harTrace struct {
+	mu                               sync.Mutex
+	dnsStart, dnsDone                time.Time
+	connectStart, connectDone        time.Time
+	tlsStart, tlsDone                time.Time
+	gotConn, wroteRequest, firstByte time.Time
+}
+
+func (t *harTrace) clientTrace() *httptrace.ClientTrace {
+	at := func(field *time.Time) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if field.IsZero() {
+			*field = time.Now()
+		}
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { at(&t.dnsStart) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { at(&t.dnsDone) },
+		ConnectStart:         func(string, string) { at(&t.connectStart) },
+		ConnectDone:          func(string, string, error) { at(&t.connectDone) },
+		TLSHandshakeStart:    func() { at(&t.tlsStart) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { at(&t.tlsDone) },
+		GotConn:              func(httptrace.GotConnInfo) { at(&t.gotConn) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { at(&t.wroteRequest) },
+		GotFirstResponseByte: func() { at(&t.firstByte) },
+	}
+}
+
+// millis is the time from start to end, -1 unless both are known
+func millis(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return -1
+	}
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+
+func (t *harTrace) timings(start time.Time) harTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timings := harTimings{
+		Blocked: -1,
+		DNS:     millis(t.dnsStart, t.dnsDone),
+		Connect: millis(t.connectStart, t.connectDone),
+		SSL:     millis(t.tlsStart, t.tlsDone),
+		Send:    max(millis(t.gotConn, t.wroteRequest), 0),
+		Wait:    max(millis(t.wroteRequest, t.firstByte), 0),
+	}
+	// Waiting for a connection is what is left before it got one
+	if !t.gotConn.IsZero() {
+		blocked := millis(start, t.gotConn)
+		for _, phase := range []float64{timings.DNS, timings.Connect} {
+			blocked -= max(phase, 0)
+		}
+		timings.Blocked = max(blocked, 0)
+	}
+	// The connect time includes the TLS handshake
+	if timings.SSL > 0 && timings.Connect >= 0 {
+		timings.Connect += timings.SSL
+	}
+	return timings
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &harTrace{}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+	start := time.Now()
+	entry := &harEntry{StartedDateTime: start, Request: harRequestOf(req)}
+	resp, err := t.base.RoundTrip(req)
+
+	t.har.mu.Lock()
+	defer t.har.mu.Unlock()
+	t.har.entries = append(t.har.entries, entry)
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Timings = trace.timings(start)
+		entry.Time = millis(start, time.Now())
+		entry.Response = harResponse{Cookies: []harHeader{}, Headers: []harHeader{}, HeadersSize: -1, BodySize: -1}
+		return nil, err
+	}
+	entry.Response = harResponseOf(resp)
+	entry.Timings = trace.timings(start)
+	entry.Time = millis(start, time.Now())
+	resp.Body = &harBody{ReadCloser: resp.Body, har: t.har, entry: entry, trace: trace, start: start, limit: t.har.BodyLimit}
+	return resp, nil
+}
+
+// harBody fills in the size, receive time and maybe text of an entry as the
+// body is read
+type harBody struct {
+	io.ReadCloser
+	har   *HARRecorder
+	entry *harEntry
+	trace *harTrace
+	start time.Time
+	limit int
+	kept  []byte
+	n     int64
+	once  sync.Once
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if keep := min(n, b.limit-len(b.kept)); keep > 0 {
+		b.kept = append(b.kept, p[:keep]...)
+	}
+	if err != nil {
+		b.done()
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+func (b *harBody) done() {
+	b.once.Do(func() {
+		end := time.Now()
+		b.har.mu.Lock()
+		defer b.har.mu.Unlock()
+		b.entry.Timings = b.trace.timings(b.start)
+		b.entry.Timings.Receive = max(millis(b.trace.firstByte, end), 0)
+		b.entry.Time = millis(b.start, end)
+		b.entry.Response.BodySize = b.n
+		b.entry.Response.Content.Size = b.n
+		if len(b.kept) > 0 {
+			b.entry.Response.Content.Text = base64.StdEncoding.EncodeToString(b.kept)
+			b.entry.Response.Content.Encoding = "base64"
+		}
+	})
+}
+
+// harSecret are the headers whose values are never recorded
+var harSecret = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+func harHeaders(header http.Header) []harHeader {
+	headers := []harHeader{}
+	for name, values := range header {
+		for _, value := range values {
+			if harSecret[http.CanonicalHeaderKey(name)] {
+				value = "[redacted]"
+			}
+			headers = append(headers, harHeader{Name: name, Value: value})
+		}
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Name < headers[j].Name })
+	return headers
+}
+
+func harRequestOf(req *http.Request) harRequest {
+	query := []harHeader{}
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, harHeader{Name: name, Value: value})
+		}
+	}
+	sort.Slice(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+	headers := harHeaders(req.Header)
+	if req.Host != "" && req.Host != req.URL.Host {
+		headers = append(headers, harHeader{Name: "Host", Value: req.Host})
+	}
+	return harRequest{
+		Method:      req.Method,
+		URL:         req.URL.Redacted(),
+		HTTPVersion: req.Proto,
+		Cookies:     []harHeader{},
+		Headers:     headers,
+		QueryString: query,
+		HeadersSize: -1,
+	}
+}
+
+func harResponseOf(resp *http.Response) harResponse {
+	text, ok := strings.CutPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+	if !ok {
+		text = http.StatusText(resp.StatusCode)
+	}
+	return harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  text,
+		HTTPVersion: resp.Proto,
+		Cookies:     []harHeader{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{MimeType: resp.Header.Get("Content-Type")},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+}
@@ -0,0 +1,105 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHARRecorder(t *testing.T) {
+	data := testData(3 << 20)
+	srv := newServer(t, serveData(data))
+	har := NewHARRecorder(16)
+	opts := testOptions()
+	opts.Concurrency = 3
+	opts.Segments = true
+	opts.Auth = Auth{Bearer: "secret"}
+	opts.HAR = har
+	d := &Downloader{Client: srv.Client()}
+	if err := d.Download(context.Background(), srv.URL+"/file.bin?sig=1", filepath.Join(t.TempDir(), "file.bin"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := har.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Error("HAR contains the bearer token")
+	}
+	var file struct{ Log harLog }
+	if err := json.Unmarshal(buf.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	entries := file.Log.Entries
+	if file.Log.Version != "1.2" || len(entries) != 4 {
+		t.Fatalf("got version %q with %d entries, want 1.2 with a HEAD and 3 GETs", file.Log.Version, len(entries))
+	}
+	if entries[0].Request.Method != "HEAD" || entries[0].Response.Status != 200 {
+		t.Errorf("first entry is %s answered with %d, want the HEAD", entries[0].Request.Method, entries[0].Response.Status)
+	}
+	var received int64
+	for _, e := range entries[1:] {
+		if e.Request.Method != "GET" || e.Response.Status != 206 || e.Response.StatusText != "Partial Content" {
+			t.Errorf("entry %s answered with %d %q, want ranged GETs", e.Request.Method, e.Response.Status, e.Response.StatusText)
+		}
+		if len(e.Request.QueryString) != 1 || e.Request.QueryString[0] != (harHeader{"sig", "1"}) {
+			t.Errorf("query string is %v", e.Request.QueryString)
+		}
+		received += e.Response.BodySize
+		var rangeHeader string
+		for _, h := range e.Request.Headers {
+			if h.Name == "Range" {
+				rangeHeader = h.Value
+			}
+		}
+		if rangeHeader == "bytes=0-1048575" {
+			text, _ := base64.StdEncoding.DecodeString(e.Response.Content.Text)
+			if !bytes.Equal(text, data[:16]) {
+				t.Errorf("kept body %x, want %x", text, data[:16])
+			}
+		}
+		if e.Timings.Send < 0 || e.Timings.Wait < 0 || e.Timings.Receive < 0 {
+			t.Errorf("timings %+v are missing a phase", e.Timings)
+		}
+	}
+	if received != int64(len(data)) {
+		t.Errorf("bodies add up to %d bytes, want %d", received, len(data))
+	}
+}
+
+func TestHARRecorderUserinfo(t *testing.T) {
+	srv := newServer(t, serveData(testData(1000)))
+	har := NewHARRecorder(0)
+	opts := testOptions()
+	opts.HAR = har
+	rawURL := strings.Replace(srv.URL, "://", "://user:hunter2@", 1) + "/file.bin"
+	d := &Downloader{Client: srv.Client()}
+	if err := d.Download(context.Background(), rawURL, filepath.Join(t.TempDir(), "file.bin"), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := har.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Error("HAR contains the password from the URL")
+	}
+	var file struct{ Log harLog }
+	if err := json.Unmarshal(buf.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Log.Entries) == 0 {
+		t.Fatal("HAR has no entries")
+	}
+	for _, e := range file.Log.Entries {
+		if !strings.Contains(e.Request.URL, "user:xxxxx@") {
+			t.Errorf("recorded URL %s, want the user with a redacted password", e.Request.URL)
+		}
+	}
+}
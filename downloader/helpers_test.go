@@ -0,0 +1,59 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testData returns size bytes of reproducible garbage
+func testData(size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return data
+}
+
+// serveData answers HEAD, GET and ranged GET for data like a static file
+// server would
+func serveData(data []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Unix(1700000000, 0), bytes.NewReader(data))
+	}
+}
+
+func newServer(t testing.TB, handler http.Handler) *httptest.Server {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testOptions keeps the logs quiet and retries quick
+func testOptions() Options {
+	return Options{
+		Logger:         NewLogger(log.New(io.Discard, "", 0), LevelError),
+		Retries:        1,
+		RetryBaseDelay: time.Millisecond,
+	}
+}
+
+// download fetches srv's /file.bin into a temporary directory and returns
+// what ended up at the destination, nil if nothing did
+func download(t testing.TB, srv *httptest.Server, opts Options) ([]byte, error) {
+	t.Helper()
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	d := &Downloader{Client: srv.Client()}
+	err := d.Download(context.Background(), srv.URL+"/file.bin", dest, opts)
+	data, readErr := os.ReadFile(dest)
+	if readErr != nil {
+		return nil, err
+	}
+	return data, err
+}
@@ -0,0 +1,71 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// HostLimiter caps the requests in flight to any one host, HEAD probes and
+// chunk requests alike. Share one between Downloads so a batch spread over
+// many servers doesn't overwhelm any of them.
+type HostLimiter struct {
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// NewHostLimiter allows perHost requests at a time to each host
+func NewHostLimiter(perHost int) *HostLimiter {
+	return &HostLimiter{perHost: max(perHost, 1), hosts: make(map[string]chan struct{})}
+}
+
+// acquire waits for a slot for the host of rawURL and returns the func
+// giving it back
+func (h *HostLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+	h.mu.Lock()
+	slots, ok := h.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, h.perHost)
+		h.hosts[host] = slots
+	}
+	h.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// hostLimitedProtocol holds a slot of the HostLimiter for every call
+type hostLimitedProtocol struct {
+	protocol
+	limiter *HostLimiter
+}
+
+func (p *hostLimitedProtocol) stat(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	release, err := p.limiter.acquire(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return p.protocol.stat(ctx, rawURL)
+}
+
+func (p *hostLimitedProtocol) get(ctx context.Context, r rangeRequest, dst io.Writer) (int64, error) {
+	release, err := p.limiter.acquire(ctx, r.url)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return p.protocol.get(ctx, r, dst)
+}
@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+)
+
+// SizeLimitError is returned when a file turns out bigger than
+// Options.MaxSize, either from its advertised size or, for files of unknown
+// size, once more than Limit bytes arrived
+type SizeLimitError struct {
+	Limit uint64
+	// The advertised size, or how far the download got before it was cut
+	// off
+	Size    uint64
+	Unknown bool
+}
+
+func (e *SizeLimitError) Error() string {
+	if e.Unknown {
+		return fmt.Sprintf("download exceeded the maximum size of %d bytes", e.Limit)
+	}
+	return fmt.Sprintf("file is %d bytes, over the maximum size of %d bytes", e.Size, e.Limit)
+}
+
+// cappedWriterAt fails every write reaching past limit
+type cappedWriterAt struct {
+	w     io.WriterAt
+	limit uint64
+}
+
+func (c *cappedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if end := uint64(off) + uint64(len(p)); end > c.limit {
+		return 0, &SizeLimitError{Limit: c.limit, Size: end, Unknown: true}
+	}
+	return c.w.WriteAt(p, off)
+}
+
+// cappedWriter fails the write that would take it past limit
+type cappedWriter struct {
+	w     io.Writer
+	limit uint64
+	n     uint64
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if c.n+uint64(len(p)) > c.limit {
+		return 0, &SizeLimitError{Limit: c.limit, Size: c.n + uint64(len(p)), Unknown: true}
+	}
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
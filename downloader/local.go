@@ -0,0 +1,154 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// fileProtocol copies file:// URLs from the local filesystem, the same way
+// ranges and all
+type fileProtocol struct {
+	opts Options
+}
+
+// localPath returns the path of a file:// URL on this machine
+func localPath(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("invalid file URL: host %s isn't this machine", u.Host)
+	}
+	if u.Path == "" {
+		return "", errors.New("invalid file URL: missing path")
+	}
+	return u.Path, nil
+}
+
+func (p *fileProtocol) stat(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	path, err := localPath(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return &RemoteFile{
+		URL:          rawURL,
+		Size:         uint64(info.Size()),
+		AcceptRanges: true,
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}, nil
+}
+
+func (p *fileProtocol) get(ctx context.Context, r rangeRequest, dst io.Writer) (int64, error) {
+	path, err := localPath(r.url)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var src io.Reader = file
+	if r.ranged {
+		src = io.NewSectionReader(file, r.start, r.length)
+	}
+	// Nothing to cancel on a local file, so stop between buffers instead
+	return copyBody(dst, &contextReader{ctx: ctx, r: src}, p.opts.BufferSize)
+}
+
+// contextReader fails reads once ctx is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// dataProtocol serves the content of data: URLs (RFC 2397)
+type dataProtocol struct {
+	opts Options
+}
+
+// ParseDataURL returns the media type and decoded content of a data: URL,
+// "data:[<mediatype>][;base64],<data>". The media type defaults to
+// text/plain;charset=US-ASCII.
+func ParseDataURL(rawURL string) (string, []byte, error) {
+	scheme, rest, ok := strings.Cut(rawURL, ":")
+	if !ok || !strings.EqualFold(scheme, "data") {
+		return "", nil, fmt.Errorf("invalid data URL: expected data: scheme")
+	}
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, errors.New("invalid data URL: missing comma before the data")
+	}
+	mediaType, isBase64 := header, false
+	if before, found := strings.CutSuffix(strings.ToLower(header), ";base64"); found {
+		mediaType, isBase64 = header[:len(before)], true
+	}
+	if mediaType == "" || strings.HasPrefix(mediaType, ";") {
+		mediaType = "text/plain" + mediaType
+		if !strings.Contains(mediaType, "charset=") {
+			mediaType += ";charset=US-ASCII"
+		}
+	}
+
+	unescaped, err := url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data URL: %w", err)
+	}
+	if !isBase64 {
+		return mediaType, []byte(unescaped), nil
+	}
+	// Padding is often left off
+	data, err := base64.StdEncoding.DecodeString(unescaped)
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(unescaped, "="))
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid data URL: bad base64: %w", err)
+	}
+	return mediaType, data, nil
+}
+
+func (p *dataProtocol) stat(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	mediaType, data, err := ParseDataURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteFile{URL: rawURL, Size: uint64(len(data)), AcceptRanges: true, ContentType: mediaType}, nil
+}
+
+func (p *dataProtocol) get(ctx context.Context, r rangeRequest, dst io.Writer) (int64, error) {
+	_, data, err := ParseDataURL(r.url)
+	if err != nil {
+		return 0, err
+	}
+	if r.ranged {
+		if r.start > int64(len(data)) {
+			return 0, ErrRangeNotSatisfiable
+		}
+		data = data[r.start:min(r.start+r.length, int64(len(data)))]
+	}
+	return copyBody(dst, bytes.NewReader(data), p.opts.BufferSize)
+}
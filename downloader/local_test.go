@@ -0,0 +1,98 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDataURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		mediaType string
+		data      string
+		err       bool
+	}{
+		{url: "data:,hello", mediaType: "text/plain;charset=US-ASCII", data: "hello"},
+		{url: "data:text/html,%3Ch1%3Ehi%3C%2Fh1%3E", mediaType: "text/html", data: "<h1>hi</h1>"},
+		{url: "data:;charset=utf-8,h%C3%A9", mediaType: "text/plain;charset=utf-8", data: "hé"},
+		{url: "data:application/octet-stream;base64,aGVsbG8=", mediaType: "application/octet-stream", data: "hello"},
+		{url: "DATA:;BASE64,aGVsbG8", mediaType: "text/plain;charset=US-ASCII", data: "hello"},
+		{url: "data:,", mediaType: "text/plain;charset=US-ASCII", data: ""},
+		{url: "data:text/plain", err: true},
+		{url: "data:;base64,not base64!", err: true},
+		{url: "data:,100%", err: true},
+		{url: "http://example.com/", err: true},
+	}
+	for _, tt := range tests {
+		mediaType, data, err := ParseDataURL(tt.url)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseDataURL(%q) error = %v, want error %v", tt.url, err, tt.err)
+			continue
+		}
+		if mediaType != tt.mediaType || string(data) != tt.data {
+			t.Errorf("ParseDataURL(%q) = %q, %q, want %q, %q", tt.url, mediaType, data, tt.mediaType, tt.data)
+		}
+	}
+}
+
+func TestDownloadLocal(t *testing.T) {
+	data := testData(1<<20 + 123)
+	src := filepath.Join(t.TempDir(), "source file.bin")
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fileURL := (&url.URL{Scheme: "file", Path: src}).String()
+	small := testData(1000)
+	dataURL := "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(small)
+
+	tests := []struct {
+		name string
+		url  string
+		want []byte
+	}{
+		{name: "file", url: fileURL, want: data},
+		{name: "file on localhost", url: "file://localhost" + (&url.URL{Path: src}).EscapedPath(), want: data},
+		{name: "data", url: dataURL, want: small},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.VerifySize = true
+			opts.ChunkSize = 256 << 10
+			dest := filepath.Join(t.TempDir(), "file.bin")
+			if err := (&Downloader{}).Download(context.Background(), tt.url, dest, opts); err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := os.ReadFile(dest); !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes that don't match the source", len(got))
+			}
+		})
+	}
+}
+
+func TestDownloadLocalErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "missing file", url: "file://" + filepath.Join(dir, "missing")},
+		{name: "directory", url: "file://" + dir},
+		{name: "remote host", url: "file://example.com/etc/passwd"},
+		{name: "no comma", url: "data:text/plain;base64"},
+		{name: "bad base64", url: "data:;base64,%%%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "file.bin")
+			if err := (&Downloader{}).Download(context.Background(), tt.url, dest, testOptions()); err == nil {
+				t.Error("download succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"fmt"
+	"log"
+)
+
+// Logger receives the package's messages. The arguments are handled like
+// log.Println's.
+type Logger interface {
+	Debug(v ...any)
+	Info(v ...any)
+	Error(v ...any)
+}
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+// NewLogger returns a Logger writing every message of at least level to l
+func NewLogger(l *log.Logger, level Level) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	level Level
+}
+
+func (s *stdLogger) print(level Level, v []any) {
+	if level >= s.level {
+		s.l.Output(3, fmt.Sprintln(v...))
+	}
+}
+
+func (s *stdLogger) Debug(v ...any) { s.print(LevelDebug, v) }
+func (s *stdLogger) Info(v ...any)  { s.print(LevelInfo, v) }
+func (s *stdLogger) Error(v ...any) { s.print(LevelError, v) }
+
+// logger is Options.Logger, or the standard logger at LevelInfo if unset
+func (o Options) logger() Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return NewLogger(log.Default(), LevelInfo)
+}
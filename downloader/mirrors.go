@@ -0,0 +1,145 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// statMirrors HEADs every URL at once. A mirror whose HEAD fails is dropped,
+// the others have to agree with the primary on the size (and ETag, where
+// both sides send one) of the file. The URLs left come back in the order of
+// urls with their answers, the primary first, along with the index of the
+// one that answered quickest.
+func statMirrors(ctx context.Context, client *http.Client, urls []string, opts Options) ([]string, []*RemoteFile, int, error) {
+	remotes := make([]*RemoteFile, len(urls))
+	errs := make([]error, len(urls))
+	took := make([]time.Duration, len(urls))
+	var wg sync.WaitGroup
+	for i, rawURL := range urls {
+		wg.Add(1)
+		go func(i int, rawURL string, opts Options) {
+			defer wg.Done()
+			began := time.Now()
+			remotes[i], errs[i] = stat(ctx, client, rawURL, opts)
+			took[i] = time.Since(began)
+		}(i, rawURL, opts)
+		// Only the primary is asked whether it changed
+		opts.ifModifiedSince = time.Time{}
+	}
+	wg.Wait()
+
+	if errs[0] != nil {
+		return nil, nil, 0, fmt.Errorf("%s: %w", urls[0], errs[0])
+	}
+	primary := remotes[0]
+	if primary.NotModified {
+		return urls[:1], remotes[:1], 0, nil
+	}
+	kept, answers := urls[:1:1], remotes[:1:1]
+	fastest, best := 0, took[0]
+	for i := 1; i < len(urls); i++ {
+		rawURL, remote := urls[i], remotes[i]
+		if errs[i] != nil {
+			opts.Logger.Info("Dropping mirror", rawURL, "-", errs[i])
+			continue
+		}
+		if remote.UnknownSize != primary.UnknownSize || remote.Size != primary.Size {
+			return nil, nil, 0, fmt.Errorf("mirror %s reports size %d, expected %d", rawURL, remote.Size, primary.Size)
+		}
+		if remote.ETag != "" && primary.ETag != "" && remote.ETag != primary.ETag {
+			return nil, nil, 0, fmt.Errorf("mirror %s reports ETag %s, expected %s", rawURL, remote.ETag, primary.ETag)
+		}
+		if took[i] < best {
+			fastest, best = len(kept), took[i]
+		}
+		kept, answers = append(kept, rawURL), append(answers, remote)
+	}
+	if len(urls) > 1 {
+		for i, rawURL := range urls {
+			if errs[i] == nil {
+				opts.Logger.Debug("HEAD", rawURL, "took", took[i].Round(time.Millisecond))
+			}
+		}
+		opts.Logger.Debug("Starting with", kept[fastest])
+	}
+	return kept, answers, fastest, nil
+}
+
+// mirrors returns the indexes into plan.URLs able to serve a request,
+// starting with from (if it can). Ranged requests only go to the mirrors
+// that take them, the others are left for a single stream.
+func (p *Plan) mirrors(ranged bool, from int) []int {
+	var usable []int
+	for i := range p.URLs {
+		j := (from + i) % len(p.URLs)
+		if !ranged || p.AcceptRanges[j] {
+			usable = append(usable, j)
+		}
+	}
+	return usable
+}
+
+// resolvedURL picks final over original so chunks skip the redirects, unless
+// that would send credentials straight to a host the redirect handed us off
+// to. Then the original is kept and the client strips them on the way.
+func resolvedURL(original, final string, opts Options) string {
+	if opts.Auth == (Auth{}) {
+		return final
+	}
+	from, err := url.Parse(original)
+	if err != nil {
+		return original
+	}
+	to, err := url.Parse(final)
+	if err != nil || to.Host != from.Host {
+		return original
+	}
+	return final
+}
+
+// fetchRange downloads length bytes starting at start into file at the same
+// offset (relative to plan.Range, if set), moving on to the next mirror
+// whenever one runs out of retries, and returns how many bytes it got.
+// Ranged requests skip the mirrors that don't support them.
+// With ranged unset no Range header is sent and the body is written from
+// offset 0; a negative length skips the length check. Only a request
+// reaching the advertised end of the file may come back short.
+// Ranged requests carry If-Range with the mirror's validator, so a file that
+// changed since the HEAD fails with errResourceChanged instead of mixing old
+// and new bytes.
+func (w *worker) fetchRange(ctx context.Context, plan *Plan, opts Options, file io.WriterAt, start, length int64, ranged bool) (int64, error) {
+	urls := plan.URLs
+	mirrors := plan.mirrors(ranged, w.mirror)
+	if plan.Range != nil {
+		start += int64(plan.Range.Start)
+		file = &shiftedWriterAt{w: file, shift: int64(plan.Range.Start)}
+	}
+	last := length >= 0 && uint64(start+length) == plan.Remote.Size
+	var n int64
+	var err error
+	for tried := range mirrors {
+		w.mirror = mirrors[tried]
+		rawURL := urls[w.mirror]
+		r := rangeRequest{url: rawURL, start: start, length: length, ranged: ranged, last: last}
+		if ranged {
+			w.log.Debug("Fetching bytes", start, "-", start+length-1, "from", rawURL)
+			r.validator = plan.validators[w.mirror]
+		}
+
+		n, err = w.fetchWithRetry(ctx, newProtocol(rawURL, w.client, opts), r, file)
+		if err == nil || errors.Is(err, errRangeIgnored) || errors.Is(err, errResourceChanged) || ctx.Err() != nil {
+			return n, err
+		}
+		if len(mirrors) > 1 {
+			next := mirrors[(tried+1)%len(mirrors)]
+			w.log.Info("Giving up on", rawURL, "-", err, "- trying", urls[next])
+		}
+	}
+	return n, err
+}
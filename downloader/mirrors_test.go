@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPlanMirrors(t *testing.T) {
+	data := testData(100000)
+	fast := newServer(t, serveData(data))
+	slow := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		serveData(data)(w, r)
+	}))
+	broken := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	other := newServer(t, serveData(testData(5000)))
+
+	tests := []struct {
+		name    string
+		urls    []string
+		want    []string
+		fastest int
+		err     bool
+	}{
+		{name: "fastest mirror", urls: []string{slow.URL, fast.URL}, want: []string{slow.URL, fast.URL}, fastest: 1},
+		{name: "fastest primary", urls: []string{fast.URL, slow.URL}, want: []string{fast.URL, slow.URL}},
+		{name: "broken mirror dropped", urls: []string{slow.URL, broken.URL, fast.URL}, want: []string{slow.URL, fast.URL}, fastest: 1},
+		{name: "broken primary", urls: []string{broken.URL, fast.URL}, err: true},
+		{name: "different file", urls: []string{fast.URL, other.URL}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.Mirrors = tt.urls[1:]
+			plan, err := (&Downloader{}).Plan(context.Background(), tt.urls[0], "file", opts)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(plan.URLs, tt.want) {
+				t.Errorf("got URLs %v, want %v", plan.URLs, tt.want)
+			}
+			if plan.fastest != tt.fastest {
+				t.Errorf("starting on %s, want %s", plan.URLs[plan.fastest], tt.want[tt.fastest])
+			}
+		})
+	}
+}
+
+func TestDownloadStartsOnFastestMirror(t *testing.T) {
+	data := testData(1 << 20)
+	var slowGets atomic.Int32
+	slow := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			slowGets.Add(1)
+		}
+		time.Sleep(50 * time.Millisecond)
+		serveData(data)(w, r)
+	}))
+	fast := newServer(t, serveData(data))
+
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	opts.Mirrors = []string{fast.URL + "/file.bin"}
+	got, err := download(t, slow, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+	// Only the probe may have gone to the slow primary
+	if n := slowGets.Load(); n > 1 {
+		t.Errorf("%d chunks came from the slow primary", n)
+	}
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// OverwritePolicy decides whether a download replaces a file already at its
+// destination, by comparing the sizes of the two
+type OverwritePolicy int
+
+const (
+	// Replace the file only if Options.Override is set
+	OverwriteDefault OverwritePolicy = iota
+	// Always replace the file
+	OverwriteAlways
+	// Replace the file if the remote one is larger
+	OverwriteIfLarger
+	// Replace the file if the remote one has a different size
+	OverwriteIfDifferentSize
+	// Never replace the file
+	OverwriteNever
+)
+
+var overwritePolicies = map[string]OverwritePolicy{
+	"always":            OverwriteAlways,
+	"if-larger":         OverwriteIfLarger,
+	"if-different-size": OverwriteIfDifferentSize,
+	"never":             OverwriteNever,
+}
+
+// ParseOverwritePolicy accepts always, if-larger, if-different-size and
+// never
+func ParseOverwritePolicy(s string) (OverwritePolicy, error) {
+	p, ok := overwritePolicies[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown overwrite policy %q, expected always, if-larger, if-different-size or never", s)
+	}
+	return p, nil
+}
+
+func (p OverwritePolicy) String() string {
+	switch p {
+	case OverwriteDefault:
+		return "default"
+	case OverwriteAlways:
+		return "always"
+	case OverwriteIfLarger:
+		return "if-larger"
+	case OverwriteIfDifferentSize:
+		return "if-different-size"
+	case OverwriteNever:
+		return "never"
+	}
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// allows reports whether dest may be replaced by plan's remote file. The
+// sizes can't be compared if the server doesn't send one, which is an error
+// for the policies that need them.
+func (p OverwritePolicy) allows(dest string, plan *Plan, opts Options) (bool, error) {
+	switch p {
+	case OverwriteDefault:
+		return opts.Override, nil
+	case OverwriteAlways:
+		return true, nil
+	case OverwriteNever:
+		return false, nil
+	}
+
+	info, err := os.Stat(dest)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if plan.Remote.UnknownSize {
+		return false, fmt.Errorf("can't apply the overwrite policy %s to %s, the remote size is unknown", p, dest)
+	}
+	local := uint64(info.Size())
+	if p == OverwriteIfLarger {
+		return plan.Size > local, nil
+	}
+	return plan.Size != local, nil
+}
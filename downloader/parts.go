@@ -0,0 +1,180 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// PartName is the name of part i of a file split by a PartSink
+func PartName(name string, i int) string {
+	return fmt.Sprintf("%s.part%03d", name, i)
+}
+
+// PartSink is a Sink spreading the file over PartName(name, 0), 1 and so
+// on, every part partSize bytes long but the last. With the chunk size set
+// to partSize every chunk gets a file of its own. JoinParts puts the file
+// back together.
+type PartSink struct {
+	name     string
+	partSize uint64
+
+	mu    sync.Mutex
+	files []*os.File
+}
+
+var _ Sink = (*PartSink)(nil)
+
+// NewPartSink returns a PartSink for name, nothing is created before the
+// first write
+func NewPartSink(name string, partSize uint64) (*PartSink, error) {
+	if partSize == 0 {
+		return nil, errors.New("part size must be positive")
+	}
+	return &PartSink{name: name, partSize: partSize}, nil
+}
+
+// part returns the file of part i, creating it on first use
+func (s *PartSink) part(i int) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.files) <= i {
+		s.files = append(s.files, nil)
+	}
+	if s.files[i] == nil {
+		file, err := os.Create(PartName(s.name, i))
+		if err != nil {
+			return nil, err
+		}
+		s.files[i] = file
+	}
+	return s.files[i], nil
+}
+
+func (s *PartSink) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	written := 0
+	for len(p) > 0 {
+		i, inPart := uint64(off)/s.partSize, uint64(off)%s.partSize
+		n := min(uint64(len(p)), s.partSize-inPart)
+		file, err := s.part(int(i))
+		if err != nil {
+			return written, err
+		}
+		m, err := file.WriteAt(p[:n], int64(inPart))
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		off += int64(n)
+	}
+	return written, nil
+}
+
+// Truncate creates every part of a file of size bytes, at least one, and
+// removes those past its end
+func (s *PartSink) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	parts := max(int((uint64(size)+s.partSize-1)/s.partSize), 1)
+	for i := 0; i < parts; i++ {
+		file, err := s.part(i)
+		if err != nil {
+			return err
+		}
+		if err := file.Truncate(int64(min(uint64(size)-uint64(i)*s.partSize, s.partSize))); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	var extra []*os.File
+	if len(s.files) > parts {
+		extra, s.files = s.files[parts:], s.files[:parts]
+	}
+	s.mu.Unlock()
+	for _, file := range extra {
+		if file == nil {
+			continue
+		}
+		file.Close()
+		if err := os.Remove(file.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parts returns how many parts there are so far
+func (s *PartSink) Parts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.files)
+}
+
+// Close closes every part, returning the first error
+func (s *PartSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first error
+	for _, file := range s.files {
+		if file == nil {
+			continue
+		}
+		if err := file.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.files = nil
+	return first
+}
+
+// JoinParts concatenates the parts of name in order into name and returns
+// how many there were. The parts are left in place.
+func JoinParts(name string) (int, error) {
+	if _, err := os.Stat(PartName(name, 0)); err != nil {
+		return 0, err
+	}
+	temp := tempName(name)
+	out, err := os.Create(temp)
+	if err != nil {
+		return 0, err
+	}
+	parts, err := copyParts(out, name)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// temp sits in the same directory, so name appears all at once
+		err = os.Rename(temp, name)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return parts, err
+	}
+	return parts, nil
+}
+
+func copyParts(out io.Writer, name string) (int, error) {
+	for i := 0; ; i++ {
+		in, err := os.Open(PartName(name, i))
+		if errors.Is(err, fs.ErrNotExist) {
+			return i, nil
+		}
+		if err != nil {
+			return i, err
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			return i, err
+		}
+	}
+}
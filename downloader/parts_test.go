@@ -0,0 +1,122 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartSink(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		parts []int
+	}{
+		{name: "uneven", size: 2500, parts: []int{1000, 1000, 500}},
+		{name: "even", size: 2000, parts: []int{1000, 1000}},
+		{name: "smaller than a part", size: 10, parts: []int{10}},
+		{name: "empty", size: 0, parts: []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "file")
+			sink, err := NewPartSink(name, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := testData(tt.size)
+			if err := sink.Truncate(int64(tt.size)); err != nil {
+				t.Fatal(err)
+			}
+			// Straddles the part boundaries
+			for off := 0; off < len(data); off += 700 {
+				end := min(off+700, len(data))
+				if _, err := sink.WriteAt(data[off:end], int64(off)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := sink.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, want := range tt.parts {
+				info, err := os.Stat(PartName(name, i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Size() != int64(want) {
+					t.Errorf("part %d is %d bytes, want %d", i, info.Size(), want)
+				}
+			}
+			if _, err := os.Stat(PartName(name, len(tt.parts))); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("part %d exists", len(tt.parts))
+			}
+
+			parts, err := JoinParts(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parts != len(tt.parts) {
+				t.Errorf("joined %d parts, want %d", parts, len(tt.parts))
+			}
+			if got, _ := os.ReadFile(name); !bytes.Equal(got, data) {
+				t.Error("joined file doesn't match what was written")
+			}
+		})
+	}
+}
+
+func TestPartSinkShrinks(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	sink, _ := NewPartSink(name, 1000)
+	defer sink.Close()
+	if err := sink.Truncate(3000); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Truncate(1200); err != nil {
+		t.Fatal(err)
+	}
+	if sink.Parts() != 2 {
+		t.Errorf("got %d parts, want 2", sink.Parts())
+	}
+	if _, err := os.Stat(PartName(name, 2)); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("part past the end is still there")
+	}
+}
+
+func TestDownloadParts(t *testing.T) {
+	data := testData(1<<20 + 4321)
+	srv := newServer(t, serveData(data))
+	name := filepath.Join(t.TempDir(), "file.bin")
+	sink, _ := NewPartSink(name, 256<<10)
+
+	opts := testOptions()
+	opts.ChunkSize = 256 << 10
+	d := &Downloader{Client: srv.Client()}
+	if err := d.DownloadAt(context.Background(), srv.URL+"/file.bin", sink, opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if parts, err := JoinParts(name); err != nil || parts != 5 {
+		t.Fatalf("joined %d parts with error %v, want 5", parts, err)
+	}
+	if got, _ := os.ReadFile(name); !bytes.Equal(got, data) {
+		t.Error("joined file doesn't match the served one")
+	}
+}
+
+func TestJoinPartsMissing(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "file")
+	if _, err := JoinParts(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := os.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("joined a file out of nothing")
+	}
+}
@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+)
+
+// Bounds for the chunk size picked when Options.ChunkSize is 0
+const (
+	MinChunkSize = 1 << 20  // 1 MiB
+	MaxChunkSize = 64 << 20 // 64 MiB
+)
+
+// autoChunkSize splits size evenly between the workers so all of them have
+// something to do, without going so small that request overhead dominates
+// or so large that a failed chunk costs too much
+func autoChunkSize(size uint64, concurrency int) uint64 {
+	chunk := (size + uint64(concurrency) - 1) / uint64(concurrency)
+	return min(max(chunk, MinChunkSize), MaxChunkSize)
+}
+
+// Plan is what Download is going to do, worked out from the HEAD requests
+// without touching the destination
+type Plan struct {
+	// The primary and mirror URLs after following redirects
+	URLs []string
+	// Whether each of URLs takes ranged requests
+	AcceptRanges []bool
+	Dest         string
+	Remote       *RemoteFile
+	// Set when only part of the file is fetched, as Options.Range
+	Range *ByteRange
+	// The spans of Options.Ranges, sorted and merged
+	Ranges []ByteRange
+	// Bytes going into Dest, Remote.Size unless Range is set
+	Size uint64
+
+	// Ranged is false when the file has to come down as a single stream,
+	// because the size is unknown or none of URLs supports ranges
+	Ranged    bool
+	ChunkSize uint64
+	// Chunk boundaries fall on multiples of it, as Options.Align
+	Align   uint64
+	Chunks  uint64
+	Workers int
+
+	// If-Range value for each of URLs
+	validators []string
+	// Index of the URL that answered the HEAD quickest, where a single
+	// stream starts
+	fastest int
+	// Taken by workers waiting on Options.RateLimiter
+	limiterTurn *sync.Mutex
+}
+
+// Plan probes url (and its mirrors) and reports how Download would fetch it
+// with the same arguments.
+func (d *Downloader) Plan(ctx context.Context, url, dest string, opts Options) (*Plan, error) {
+	opts = opts.withDefaults()
+	return d.plan(ctx, d.httpClient(opts), append([]string{url}, opts.Mirrors...), dest, opts)
+}
+
+// destPath is where dest goes once OutputDir is applied
+func (o Options) destPath(dest string) string {
+	if o.OutputDir == "" || filepath.IsAbs(dest) {
+		return dest
+	}
+	return filepath.Join(o.OutputDir, dest)
+}
+
+// plan takes the primary URL followed by the mirrors
+func (d *Downloader) plan(ctx context.Context, client *http.Client, urls []string, dest string, opts Options) (*Plan, error) {
+	urls, remotes, fastest, err := statMirrors(ctx, client, urls, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Align&(opts.Align-1) != 0 {
+		return nil, fmt.Errorf("alignment %d is not a power of two", opts.Align)
+	}
+	// Block offsets are in the remote file, chunk offsets in the range
+	if len(opts.Blocks) > 0 && opts.Range != nil {
+		return nil, errors.New("blocks can't be checked when only fetching a range")
+	}
+	if len(opts.Ranges) > 0 && (opts.Range != nil || opts.Checksum != nil) {
+		return nil, errors.New("ranges can't be combined with a single range or a checksum")
+	}
+	if opts.Segments && (opts.ChunkSize != 0 || len(opts.Blocks) > 0 || opts.Adaptive) {
+		return nil, errors.New("segments can't be combined with a chunk size, blocks or adaptive concurrency")
+	}
+	remote := remotes[0]
+	size := remote.Size
+	ranges := mergeByteRanges(opts.Ranges)
+	for _, r := range ranges {
+		if remote.NotModified {
+			break
+		}
+		if err := checkRange(r, remote); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Range != nil && !remote.NotModified {
+		if err := checkRange(*opts.Range, remote); err != nil {
+			return nil, err
+		}
+		size = opts.Range.Len()
+	}
+	if opts.MaxSize > 0 && !remote.UnknownSize && size > opts.MaxSize {
+		return nil, &SizeLimitError{Limit: opts.MaxSize, Size: size}
+	}
+	suggested := dest == ""
+	if suggested {
+		dest = SuggestedName(urls[0], remote)
+		if opts.GuessExtension {
+			dest = withTypeExtension(dest, remote.ContentType)
+		}
+	}
+	// SuggestedName already strips directories, this is the last line of
+	// defence against a server picking where the file goes
+	if suggested && opts.OutputDir != "" && !filepath.IsLocal(dest) {
+		return nil, fmt.Errorf("refusing unsafe file name %q", dest)
+	}
+	dest = opts.destPath(dest)
+	if remote.NotModified {
+		return &Plan{URLs: urls, AcceptRanges: []bool{remote.AcceptRanges}, Dest: dest, Remote: remote}, nil
+	}
+
+	plan := &Plan{
+		URLs:         make([]string, len(urls)),
+		AcceptRanges: make([]bool, len(urls)),
+		Dest:         dest,
+		Remote:       remote,
+		Range:        opts.Range,
+		Ranges:       ranges,
+		Size:         size,
+		ChunkSize:    opts.ChunkSize,
+		Chunks:       1,
+		Workers:      1,
+		validators:   make([]string, len(urls)),
+		fastest:      fastest,
+		limiterTurn:  new(sync.Mutex),
+	}
+	for i, r := range remotes {
+		plan.URLs[i] = resolvedURL(urls[i], r.URL, opts)
+		plan.AcceptRanges[i] = r.AcceptRanges
+		plan.validators[i] = r.validator()
+		// One mirror taking ranges is enough, the chunks all go there
+		plan.Ranged = plan.Ranged || r.AcceptRanges
+	}
+	plan.Ranged = plan.Ranged && !remote.UnknownSize
+	switch {
+	case opts.Segments:
+		plan.ChunkSize = max((size+uint64(opts.Concurrency)-1)/uint64(opts.Concurrency), 1)
+	case plan.ChunkSize == 0:
+		plan.ChunkSize = autoChunkSize(size, opts.Concurrency)
+	}
+	if align := opts.Align; align > 1 {
+		plan.Align = align
+		plan.ChunkSize = (plan.ChunkSize + align - 1) / align * align
+	}
+	if plan.Range != nil && !plan.Ranged {
+		return nil, fmt.Errorf("can't fetch only bytes %s, the server doesn't support ranges", plan.Range)
+	}
+	if len(plan.Ranges) > 0 && !plan.Ranged {
+		return nil, errors.New("can't fetch only some ranges, the server doesn't support ranges")
+	}
+	if plan.Ranged {
+		plan.Chunks = (size + plan.ChunkSize - 1) / plan.ChunkSize
+		plan.Workers = max(min(opts.Concurrency, int(plan.Chunks)), 1)
+	}
+	return plan, nil
+}
+
+// checkRange makes sure r lies within the remote file
+func checkRange(r ByteRange, remote *RemoteFile) error {
+	if remote.UnknownSize {
+		return fmt.Errorf("can't fetch only bytes %s of a file of unknown size", r)
+	}
+	if r.End >= remote.Size {
+		return fmt.Errorf("range %s is outside the file of %d bytes", r, remote.Size)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// RemoteFile is what the server tells us about a download before fetching it
+type RemoteFile struct {
+	// Where the HEAD ended up after following redirects
+	URL string
+	// Status line of the final response, such as "200 OK", empty for FTP
+	Status string
+	Size   uint64
+	// Set when the server didn't send a Content-Length
+	UnknownSize bool
+	// Whether the server advertised Accept-Ranges: bytes. Always set for FTP,
+	// where REST support only shows when a chunk is fetched.
+	AcceptRanges bool
+	// Name from the Content-Disposition header, unsanitized, empty if none
+	Filename     string
+	ContentType  string
+	ETag         string
+	LastModified string
+	// Set when the server answered 304 to If-Modified-Since, none of the
+	// other fields but URL are filled in then
+	NotModified bool
+}
+
+// validator is what goes into If-Range: a strong ETag if there is one, as
+// weak ones aren't allowed there, else Last-Modified
+func (r *RemoteFile) validator() string {
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// Stat issues a HEAD request for rawURL, or asks an FTP server for the SIZE
+// and MDTM of it. If HEAD is refused or doesn't tell the size, a GET for
+// the first byte is tried and the size taken from its Content-Range. Only
+// the request related fields of opts (such as Auth) are used.
+func Stat(ctx context.Context, rawURL string, opts Options) (*RemoteFile, error) {
+	return (&Downloader{}).Stat(ctx, rawURL, opts)
+}
+
+// Stat is like the package level Stat, going through d.Client if it is set
+func (d *Downloader) Stat(ctx context.Context, rawURL string, opts Options) (*RemoteFile, error) {
+	return stat(ctx, d.httpClient(opts), rawURL, opts)
+}
+
+func stat(ctx context.Context, client *http.Client, rawURL string, opts Options) (*RemoteFile, error) {
+	return newProtocol(rawURL, client, opts).stat(ctx, rawURL)
+}
+
+// GetFileSize asks the server for the size of url with a HEAD request,
+// returning ErrUnknownSize if it doesn't say
+func GetFileSize(ctx context.Context, url string, opts Options) (uint64, error) {
+	return (&Downloader{}).GetFileSize(ctx, url, opts)
+}
+
+// GetFileSize is like the package level GetFileSize, going through d.Client
+// if it is set
+func (d *Downloader) GetFileSize(ctx context.Context, url string, opts Options) (uint64, error) {
+	remote, err := d.Stat(ctx, url, opts)
+	if err != nil {
+		return 0, err
+	}
+	if remote.UnknownSize {
+		return 0, ErrUnknownSize
+	}
+	return remote.Size, nil
+}
+
+// SuggestedName picks a local file name for rawURL: the Content-Disposition
+// filename if the server sent one, else the last segment of the URL path,
+// else index.html, or data for a data: URL. The result is always a plain
+// name without directories.
+func SuggestedName(rawURL string, remote *RemoteFile) string {
+	if remote != nil {
+		if name := sanitizeName(remote.Filename); name != "" {
+			return name
+		}
+	}
+	if isData(rawURL) {
+		return "data"
+	}
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := sanitizeName(path.Base(u.Path)); name != "" {
+			return name
+		}
+	}
+	return "index.html"
+}
+
+// preferredExtensions picks the usual extension for types that
+// mime.ExtensionsByType only knows under alphabetically earlier ones, like
+// .jfif for image/jpeg
+var preferredExtensions = map[string]string{
+	"application/javascript": ".js",
+	"application/xml":        ".xml",
+	"audio/mpeg":             ".mp3",
+	"image/jpeg":             ".jpg",
+	"image/svg+xml":          ".svg",
+	"image/tiff":             ".tiff",
+	"text/html":              ".html",
+	"text/javascript":        ".js",
+	"text/plain":             ".txt",
+	"text/xml":               ".xml",
+	"video/mp4":              ".mp4",
+	"video/mpeg":             ".mpeg",
+	"video/quicktime":        ".mov",
+}
+
+// withTypeExtension appends an extension for contentType to a name that has
+// none: the usual one for common types, else the one matching the subtype,
+// else the first mime.ExtensionsByType knows of. Other names are returned as
+// they are.
+func withTypeExtension(name, contentType string) string {
+	if path.Ext(name) != "" || contentType == "" {
+		return name
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return name
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return name + ext
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return name
+	}
+	_, subtype, _ := strings.Cut(mediaType, "/")
+	for _, ext := range exts {
+		if ext == "."+subtype {
+			return name + ext
+		}
+	}
+	return name + exts[0]
+}
+
+// sanitizeName strips any directory components and characters that are
+// illegal in file names on common filesystems
+func sanitizeName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." || strings.Trim(name, ".") == "" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,134 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestGetFileSize(t *testing.T) {
+	data := testData(100000)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		size    uint64
+		err     error
+		status  int
+		failed  bool
+	}{
+		{name: "content length", handler: serveData(data), size: 100000},
+		{
+			name: "no content length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					w.Write(data)
+					w.(http.Flusher).Flush()
+				}
+			},
+			err: ErrUnknownSize,
+		},
+		{
+			name: "HEAD refused",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				serveData(data)(w, r)
+			},
+			size: 100000,
+		},
+		{
+			name: "HEAD failure",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			status: http.StatusInternalServerError,
+		},
+		{
+			// Hangs up without a reply, so there is no response at all
+			name: "connection closed",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					panic(err)
+				}
+				conn.Close()
+			},
+			failed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t, tt.handler)
+			d := &Downloader{Client: srv.Client()}
+			size, err := d.GetFileSize(context.Background(), srv.URL+"/file.bin", testOptions())
+
+			var statusErr *HTTPStatusError
+			switch {
+			case tt.status != 0:
+				if !errors.As(err, &statusErr) || statusErr.Code != tt.status {
+					t.Fatalf("got error %v, want status %d", err, tt.status)
+				}
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+			case tt.failed:
+				if err == nil {
+					t.Fatalf("got size %d, want an error", size)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case size != tt.size:
+				t.Errorf("got size %d, want %d", size, tt.size)
+			}
+		})
+	}
+}
+
+func TestSuggestedName(t *testing.T) {
+	tests := []struct {
+		url      string
+		filename string
+		want     string
+	}{
+		{url: "http://example.com/a/file.iso", want: "file.iso"},
+		{url: "http://example.com/", want: "index.html"},
+		{url: "http://example.com/file", filename: "report.pdf", want: "report.pdf"},
+		{url: "http://example.com/file", filename: "../../etc/passwd", want: "passwd"},
+		{url: "http://example.com/file", filename: "..", want: "file"},
+		{url: "http://example.com/a%3Fb", want: "ab"},
+		{url: "data:,hello", want: "data"},
+		{url: "file:///tmp/file.iso", want: "file.iso"},
+	}
+	for _, tt := range tests {
+		got := SuggestedName(tt.url, &RemoteFile{Filename: tt.filename})
+		if got != tt.want {
+			t.Errorf("SuggestedName(%q, %q) = %q, want %q", tt.url, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestWithTypeExtension(t *testing.T) {
+	tests := []struct {
+		name, contentType, want string
+	}{
+		{"report", "application/pdf", "report.pdf"},
+		{"report", "application/pdf; charset=binary", "report.pdf"},
+		{"report.txt", "application/pdf", "report.txt"},
+		{"report", "", "report"},
+		{"report", "application/x-unknown-to-anyone", "report"},
+		{"photo", "image/jpeg", "photo.jpg"},
+		{"readme", "text/plain; charset=utf-8", "readme.txt"},
+		{"index", "text/html", "index.html"},
+		{"clip", "video/mp4", "clip.mp4"},
+		{"icon", "image/PNG", "icon.png"},
+	}
+	for _, tt := range tests {
+		if got := withTypeExtension(tt.name, tt.contentType); got != tt.want {
+			t.Errorf("withTypeExtension(%q, %q) = %q, want %q", tt.name, tt.contentType, got, tt.want)
+		}
+	}
+}
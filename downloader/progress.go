@@ -0,0 +1,53 @@
+package downloader
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ProgressFunc is called with the bytes of the file present so far and its
+// total size, 0 if unknown
+type ProgressFunc func(downloaded, total int64)
+
+// progressInterval is the least time between two ProgressFunc calls. The
+// call for the last byte is never dropped.
+const progressInterval = 100 * time.Millisecond
+
+// progressTracker sums up the Status deltas of one Download for its
+// ProgressFunc
+type progressTracker struct {
+	mu         sync.Mutex
+	fn         ProgressFunc
+	downloaded int64
+	last       time.Time
+}
+
+func (p *progressTracker) add(s Status) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.downloaded += int64(s.Downloaded)
+	total := int64(s.Total)
+	if s.Time.Sub(p.last) < progressInterval && (total == 0 || p.downloaded != total) {
+		return
+	}
+	p.last = s.Time
+	p.fn(p.downloaded, total)
+}
+
+// countingWriter remembers how many bytes went through it, handing every
+// write to report as well if set
+type countingWriter struct {
+	w      io.Writer
+	n      int64
+	report func(n int)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if c.report != nil && n > 0 {
+		c.report(n)
+	}
+	return n, err
+}
@@ -0,0 +1,250 @@
+package downloader
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// protocol fetches files for one family of URL schemes
+type protocol interface {
+	// stat learns what it can about rawURL without downloading it
+	stat(ctx context.Context, rawURL string) (*RemoteFile, error)
+	// get makes a single attempt at writing the file into dst, from r.start
+	// and at most r.length bytes of it if r.ranged is set
+	get(ctx context.Context, r rangeRequest, dst io.Writer) (int64, error)
+}
+
+type rangeRequest struct {
+	url           string
+	start, length int64
+	ranged        bool
+	// Expected validator of the remote file, sent as If-Range over HTTP
+	validator string
+	// Set when the request reaches the advertised end of the file, which
+	// some servers get wrong. Fewer bytes than asked for are accepted then.
+	last bool
+}
+
+func newProtocol(rawURL string, client *http.Client, opts Options) protocol {
+	var p protocol = &httpProtocol{client: client, opts: opts}
+	switch {
+	case isFTP(rawURL):
+		p = &ftpProtocol{opts: opts}
+	case isFile(rawURL):
+		p = &fileProtocol{opts: opts}
+	case isData(rawURL):
+		p = &dataProtocol{opts: opts}
+	}
+	if opts.HostLimiter != nil {
+		p = &hostLimitedProtocol{protocol: p, limiter: opts.HostLimiter}
+	}
+	return p
+}
+
+// copyBody copies src into dst through a pooled buffer
+func copyBody(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	buf := getBuffer(size)
+	defer putBuffer(buf)
+	return io.CopyBuffer(dst, src, *buf)
+}
+
+type httpProtocol struct {
+	client *http.Client
+	opts   Options
+}
+
+func (p *httpProtocol) stat(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	req, err := p.opts.newRequest(ctx, http.MethodHead, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if since := p.opts.ifModifiedSince; !since.IsZero() {
+		req.Header.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return &RemoteFile{URL: resp.Request.URL.String(), Status: resp.Status, NotModified: true}, nil
+	}
+	// Some servers refuse HEAD or leave out the size, but answer a ranged
+	// GET properly. The headers of an error page say nothing about the
+	// file, so none of them make it into a plan.
+	if resp.StatusCode != http.StatusOK {
+		if remote, err := p.probeRange(ctx, rawURL); err == nil {
+			return remote, nil
+		}
+		return nil, statusError(resp)
+	}
+	remote, err := remoteFromResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	if remote.UnknownSize {
+		if probed, err := p.probeRange(ctx, rawURL); err == nil && !probed.UnknownSize {
+			return probed, nil
+		}
+	}
+	return remote, nil
+}
+
+// probeRange learns about rawURL from a GET for its first byte, reading the
+// size from Content-Range. A server ignoring the range gives away the size
+// in Content-Length instead, and the body is left unread.
+func (p *httpProtocol) probeRange(ctx context.Context, rawURL string) (*RemoteFile, error) {
+	req, err := p.opts.newRequest(ctx, http.MethodGet, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return remoteFromResponse(resp)
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		// A 416 to bytes=0-0 means an empty file, as "bytes */0"
+		size, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err != nil {
+			return nil, err
+		}
+		remote, err := remoteFromResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		remote.Size, remote.UnknownSize, remote.AcceptRanges = size, false, true
+		return remote, nil
+	}
+	return nil, statusError(resp)
+}
+
+// parseContentRange returns the complete length from "bytes 0-0/12345" or
+// "bytes */12345"
+func parseContentRange(value string) (uint64, error) {
+	_, total, ok := strings.Cut(value, "/")
+	if !ok || !strings.HasPrefix(value, "bytes ") || total == "*" {
+		return 0, fmt.Errorf("unusable Content-Range %q", value)
+	}
+	size, err := strconv.ParseUint(total, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid Content-Range %q: %w", value, err)
+	}
+	return size, nil
+}
+
+// remoteFromResponse fills in a RemoteFile from the headers of resp. All of
+// them come from the final response, so Accept-Ranges is the answer of the
+// server we are actually going to fetch from.
+func remoteFromResponse(resp *http.Response) (*RemoteFile, error) {
+	remote := &RemoteFile{
+		URL:          resp.Request.URL.String(),
+		Status:       resp.Status,
+		AcceptRanges: resp.Header.Get("Accept-Ranges") == "bytes",
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil {
+			remote.Filename = params["filename"]
+		}
+	}
+
+	contentlength := resp.Header.Get("Content-Length")
+	if contentlength == "" {
+		remote.UnknownSize = true
+		return remote, nil
+	}
+	var err error
+	remote.Size, err = strconv.ParseUint(contentlength, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %w", err)
+	}
+	return remote, nil
+}
+
+func (p *httpProtocol) get(ctx context.Context, r rangeRequest, dst io.Writer) (int64, error) {
+	request, err := p.opts.newRequest(ctx, http.MethodGet, r.url)
+	if err != nil {
+		return 0, err
+	}
+	if r.ranged {
+		request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.start, r.start+r.length-1))
+		if r.validator != "" {
+			request.Header.Set("If-Range", r.validator)
+		}
+	}
+
+	resp, err := p.client.Do(request)
+	if err != nil {
+		p.opts.logger().Debug("Error while downloading", request.URL, "-", err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, statusError(resp)
+	}
+	// A 200 to a ranged request is the whole file, writing it at the chunk's
+	// offset would corrupt the output. With If-Range it means the file has
+	// changed since we learned its validator.
+	if r.ranged && resp.StatusCode != http.StatusPartialContent {
+		if r.validator != "" && resp.Header.Get("ETag") != r.validator && resp.Header.Get("Last-Modified") != r.validator {
+			return 0, errResourceChanged
+		}
+		return 0, errRangeIgnored
+	}
+
+	// Offsets into a compressed body mean nothing, so a ranged response has
+	// to be raw bytes. A whole body can be decoded on the fly.
+	var body io.Reader = resp.Body
+	switch enc := resp.Header.Get("Content-Encoding"); enc {
+	case "", "identity":
+	case "gzip", "deflate":
+		if r.ranged {
+			return 0, fmt.Errorf("%w: response is %s encoded", errRangeIgnored, enc)
+		}
+		if body, err = decodeBody(enc, resp.Body); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unsupported Content-Encoding %q", enc)
+	}
+	return copyBody(dst, body, p.opts.BufferSize)
+}
+
+func decodeBody(enc string, r io.Reader) (io.Reader, error) {
+	if enc == "gzip" {
+		return gzip.NewReader(r)
+	}
+	return zlib.NewReader(r)
+}
+
+func isFTP(rawURL string) bool {
+	lower := strings.ToLower(rawURL)
+	return strings.HasPrefix(lower, "ftp://") || strings.HasPrefix(lower, "ftps://")
+}
+
+func isFile(rawURL string) bool {
+	return strings.HasPrefix(strings.ToLower(rawURL), "file:")
+}
+
+func isData(rawURL string) bool {
+	return strings.HasPrefix(strings.ToLower(rawURL), "data:")
+}
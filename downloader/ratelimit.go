@@ -0,0 +1,50 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// NewRateLimiter returns a limiter allowing bytesPerSec bytes per second.
+// Share one limiter between Downloads to cap their combined throughput.
+func NewRateLimiter(bytesPerSec uint64) *rate.Limiter {
+	burst := 32 * 1024
+	if bytesPerSec < uint64(burst) {
+		burst = max(int(bytesPerSec), 1)
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+}
+
+// limitedWriter blocks every Write until the shared limiter hands out enough
+// tokens for it. The workers of one download take turns through turn, so
+// each download has a single reservation queued at a time and downloads
+// sharing the limiter get an even split however many workers they run.
+type limitedWriter struct {
+	ctx     context.Context
+	limiter *rate.Limiter
+	turn    *sync.Mutex
+	w       io.Writer
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), l.limiter.Burst())
+		l.turn.Lock()
+		err := l.limiter.WaitN(l.ctx, n)
+		l.turn.Unlock()
+		if err != nil {
+			return written, err
+		}
+		n, err = l.w.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
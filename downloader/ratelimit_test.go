@@ -0,0 +1,56 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countWriter struct{ n atomic.Int64 }
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func TestRateLimiterFairShare(t *testing.T) {
+	const rate = 4 << 20
+	limiter := NewRateLimiter(rate)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	// A download with four workers against one with a single worker
+	var busy, lone countWriter
+	var wg sync.WaitGroup
+	start := time.Now()
+	for _, dl := range []struct {
+		w       *countWriter
+		workers int
+	}{{&busy, 4}, {&lone, 1}} {
+		turn := new(sync.Mutex)
+		for i := 0; i < dl.workers; i++ {
+			wg.Add(1)
+			go func(w io.Writer) {
+				defer wg.Done()
+				lw := &limitedWriter{ctx: ctx, limiter: limiter, turn: turn, w: w}
+				buf := make([]byte, 64<<10)
+				for ctx.Err() == nil {
+					lw.Write(buf)
+				}
+			}(dl.w)
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	got := busy.n.Load() + lone.n.Load()
+	if limit := int64(rate*elapsed.Seconds()) + int64(limiter.Burst())*2; got > limit {
+		t.Errorf("wrote %d bytes in %v, want at most %d", got, elapsed, limit)
+	}
+	if share := float64(lone.n.Load()) / float64(got); share < 0.4 {
+		t.Errorf("single worker got %.0f%% of the bandwidth, want about half", share*100)
+	}
+}
@@ -0,0 +1,129 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Open starts downloading url with the same concurrent ranged requests as
+// Download and returns a reader yielding the file's bytes in order, without
+// a temporary file. Finished chunks wait in memory, up to opts.WriteBehind
+// or DefaultWriteBehind, until everything before them has been read, and an
+// unset ChunkSize splits that memory between the workers. A Checksum in opts
+// is checked after the last byte, a mismatch is returned by Read instead of
+// io.EOF. Closing the reader stops the download.
+func (d *Downloader) Open(ctx context.Context, url string, opts Options) (io.ReadCloser, error) {
+	opts = opts.withDefaults()
+	if len(opts.Ranges) > 0 {
+		return nil, errors.New("ranges leave holes, they can't be read in order")
+	}
+	if opts.Segments {
+		return nil, errors.New("segments are all fetched at once, they can't be read in order")
+	}
+	opts.ordered = true
+	if opts.ChunkSize == 0 {
+		limit := opts.WriteBehind
+		if limit == 0 {
+			limit = DefaultWriteBehind
+		}
+		opts.ChunkSize = max(limit/uint64(opts.Concurrency), MinChunkSize)
+	}
+	client := d.httpClient(opts)
+
+	plan, err := d.plan(ctx, client, append([]string{url}, opts.Mirrors...), "", opts)
+	if err != nil {
+		return nil, err
+	}
+	var h hash.Hash
+	if opts.Checksum != nil {
+		if h, err = opts.Checksum.newHash(); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	r := &downloadReader{PipeReader: pr, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		defer cancel()
+		var w io.Writer = pw
+		if h != nil {
+			w = io.MultiWriter(pw, h)
+		}
+		err := d.fetchOrdered(ctx, client, plan, opts, &sequentialWriter{w: w})
+		if err == nil && h != nil {
+			err = opts.Checksum.check(h.Sum(nil))
+		}
+		pw.CloseWithError(err)
+	}()
+	return r, nil
+}
+
+// fetchOrdered downloads plan into file, which has to be written front to
+// back
+func (d *Downloader) fetchOrdered(ctx context.Context, client *http.Client, plan *Plan, opts Options, file io.WriterAt) error {
+	if plan.Remote.UnknownSize {
+		opts.Logger.Info("Size unknown, downloading as a single stream")
+		if opts.MaxSize > 0 {
+			file = &cappedWriterAt{w: file, limit: opts.MaxSize}
+		}
+		opts.report(Status{})
+		defer opts.running(0)()
+		_, err := newWorker(client, plan, opts).fetchRange(ctx, plan, opts, file, 0, -1, false)
+		return err
+	}
+	if plan.Size == 0 {
+		opts.report(Status{})
+		return nil
+	}
+
+	state := &resumeState{}
+	state.Reset(plan)
+	opts.report(Status{Total: int(plan.Size)})
+	if err := d.fetchChunks(ctx, client, plan, opts, state, file); err != nil {
+		return err
+	}
+	if downloaded := state.Downloaded(); downloaded != plan.Size {
+		return &IncompleteError{Downloaded: downloaded, Size: plan.Size}
+	}
+	return nil
+}
+
+// downloadReader is what Open returns
+type downloadReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+	// Closed once the download has stopped
+	done chan struct{}
+}
+
+// Close stops the download and waits for its workers to be gone
+func (r *downloadReader) Close() error {
+	r.PipeReader.Close()
+	r.cancel()
+	<-r.done
+	return nil
+}
+
+// sequentialWriter passes writes at increasing offsets on to w. Bytes before
+// the current position are dropped, as when a single stream starts over
+// after chunks were already written, and skipping ahead is an error.
+type sequentialWriter struct {
+	w   io.Writer
+	pos int64
+}
+
+func (s *sequentialWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off > s.pos {
+		return 0, fmt.Errorf("write at %d skips ahead of %d", off, s.pos)
+	}
+	skip := min(s.pos-off, int64(len(p)))
+	n, err := s.w.Write(p[skip:])
+	s.pos += int64(n)
+	return int(skip) + n, err
+}
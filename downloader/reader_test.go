@@ -0,0 +1,150 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	data := testData(1<<20 + 777)
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		writeBehind uint64
+	}{
+		{name: "ranged", handler: serveData(data)},
+		{name: "little memory", handler: serveData(data), writeBehind: 64 << 10},
+		{
+			// Every later chunk finishes before the first one
+			name: "slow first chunk",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+					time.Sleep(50 * time.Millisecond)
+				}
+				serveData(data)(w, r)
+			},
+			writeBehind: 256 << 10,
+		},
+		{
+			name: "no ranges",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				r.Header.Del("Range")
+				w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+				if r.Method == http.MethodGet {
+					w.Write(data)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t, tt.handler)
+			opts := testOptions()
+			opts.Concurrency = 8
+			opts.ChunkSize = 64 << 10
+			opts.WriteBehind = tt.writeBehind
+			d := &Downloader{Client: srv.Client()}
+			r, err := d.Open(context.Background(), srv.URL+"/file.bin", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("read %d bytes that don't match the served file", len(got))
+			}
+		})
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	data := testData(1 << 20)
+	sum := sha256.Sum256([]byte("something else"))
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		checksum *Checksum
+		check    func(error) bool
+	}{
+		{
+			name: "failing chunk",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if strings.HasPrefix(r.Header.Get("Range"), "bytes=131072-") {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				serveData(data)(w, r)
+			},
+			check: func(err error) bool {
+				var statusErr *HTTPStatusError
+				return errors.As(err, &statusErr)
+			},
+		},
+		{
+			name:     "checksum mismatch",
+			handler:  serveData(data),
+			checksum: &Checksum{Algo: "sha256", Sum: sum[:]},
+			check: func(err error) bool {
+				var mismatch *ChecksumMismatchError
+				return errors.As(err, &mismatch)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t, tt.handler)
+			opts := testOptions()
+			opts.Concurrency = 4
+			opts.ChunkSize = 64 << 10
+			opts.WriteBehind = 128 << 10
+			opts.Checksum = tt.checksum
+			d := &Downloader{Client: srv.Client()}
+			r, err := d.Open(context.Background(), srv.URL+"/file.bin", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := io.ReadAll(r); !tt.check(err) {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenClose(t *testing.T) {
+	data := testData(4 << 20)
+	srv := newServer(t, serveData(data))
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	opts.WriteBehind = 256 << 10
+	d := &Downloader{Client: srv.Client()}
+	r, err := d.Open(context.Background(), srv.URL+"/file.bin", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1000)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[:1000]) {
+		t.Error("first bytes don't match the served file")
+	}
+	// Returns once the workers are gone, with the rest never read
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(buf); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("read after close got %v, want %v", err, io.ErrClosedPipe)
+	}
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ErrNoRemoteChecksum is returned by RemoteChecksum when none of the
+// checksum files next to the URL exist
+var ErrNoRemoteChecksum = errors.New("no checksum file found next to the file")
+
+// remoteSumExts are tried in order, the strongest first
+var remoteSumExts = []string{"sha256", "sha1", "md5"}
+
+// maxSumFileSize keeps a bogus checksum URL from downloading a whole file
+const maxSumFileSize = 1 << 20
+
+// RemoteChecksum looks for a checksum file published next to rawURL, that is
+// rawURL with .sha256, .sha1 or .md5 appended, and returns the first digest
+// found. The files may hold a bare digest or lines in the format of
+// sha256sum and friends, "digest  name", of which the one naming the file
+// is used.
+func (d *Downloader) RemoteChecksum(ctx context.Context, rawURL string, opts Options) (*Checksum, error) {
+	opts = opts.withDefaults()
+	client := d.httpClient(opts)
+	name := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		name = path.Base(u.Path)
+	}
+
+	for _, algo := range remoteSumExts {
+		sumURL := rawURL + "." + algo
+		var buf bytes.Buffer
+		w := &cappedWriter{w: &buf, limit: maxSumFileSize}
+		_, err := newProtocol(sumURL, client, opts).get(ctx, rangeRequest{url: sumURL, length: -1}, w)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			opts.Logger.Debug("No checksum at", sumURL, "-", err)
+			continue
+		}
+		digest, err := findDigest(buf.String(), name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sumURL, err)
+		}
+		opts.Logger.Info("Using checksum from", sumURL)
+		return NewChecksum(algo, digest)
+	}
+	return nil, ErrNoRemoteChecksum
+}
+
+// findDigest picks the digest for name out of a checksum file. A file with
+// a single entry is taken whatever name it gives.
+func findDigest(data, name string) (string, error) {
+	var digests, names []string
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		digests = append(digests, fields[0])
+		entry := ""
+		if len(fields) > 1 {
+			// A leading * marks binary mode in sha256sum output
+			entry = path.Base(strings.TrimPrefix(fields[1], "*"))
+		}
+		names = append(names, entry)
+	}
+	if len(digests) == 1 {
+		return digests[0], nil
+	}
+	for i, entry := range names {
+		if entry == name {
+			return digests[i], nil
+		}
+	}
+	if len(digests) == 0 {
+		return "", errors.New("checksum file is empty")
+	}
+	return "", fmt.Errorf("checksum file has no entry for %s", name)
+}
@@ -0,0 +1,29 @@
+package downloader
+
+import "testing"
+
+func TestFindDigest(t *testing.T) {
+	tests := []struct {
+		name, data, file, want string
+		err                    bool
+	}{
+		{name: "bare digest", data: "abc123\n", file: "file.bin", want: "abc123"},
+		{name: "single entry", data: "abc123  other.bin\n", file: "file.bin", want: "abc123"},
+		{name: "matching entry", data: "aaa  other.bin\nbbb  file.bin\n", file: "file.bin", want: "bbb"},
+		{name: "binary mode", data: "aaa  other.bin\nbbb *dir/file.bin\n", file: "file.bin", want: "bbb"},
+		{name: "comments", data: "# sums\n\nabc123  file.bin\n", file: "file.bin", want: "abc123"},
+		{name: "no entry", data: "aaa  one.bin\nbbb  two.bin\n", file: "file.bin", err: true},
+		{name: "empty", data: "\n", file: "file.bin", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findDigest(tt.data, tt.file)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
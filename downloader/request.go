@@ -0,0 +1,183 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Auth holds the credentials sent with every request. Bearer takes precedence
+// over Basic auth when both are set.
+type Auth struct {
+	Username string
+	Password string
+	Bearer   string
+}
+
+func (a Auth) apply(req *http.Request) {
+	switch {
+	case a.Bearer != "":
+		req.Header.Set("Authorization", "Bearer "+a.Bearer)
+	case a.Username != "" || a.Password != "":
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
+
+// newRequest builds a request carrying everything from opts that has to go
+// out with both the HEAD probes and the ranged GETs
+func (o Options) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Keeps Content-Length and the byte offsets of ranges about the file
+	// itself rather than some compressed form of it
+	req.Header.Set("Accept-Encoding", "identity")
+	userAgent := o.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for key, values := range o.Header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	o.Auth.apply(req)
+	return req, nil
+}
+
+// httpClient returns the client for one Download. An injected Client is
+// copied so Options can fill in a timeout and redirect policy without
+// touching the caller's, whose own CheckRedirect still runs after ours.
+// Otherwise a new client is built whose transport is
+// shared by the HEAD probes and all workers.
+func (d *Downloader) httpClient(opts Options) *http.Client {
+	var client http.Client
+	if d.Client != nil {
+		client = *d.Client
+	} else {
+		client.Transport = opts.newTransport()
+	}
+	policy := redirectPolicy(opts.MaxRedirects, opts.logger())
+	if theirs := client.CheckRedirect; theirs != nil {
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if err := policy(req, via); err != nil {
+				return err
+			}
+			return theirs(req, via)
+		}
+	} else {
+		client.CheckRedirect = policy
+	}
+	if opts.Timeout > 0 {
+		client.Timeout = opts.Timeout
+	}
+	if opts.Jar != nil {
+		client.Jar = opts.Jar
+	}
+	if opts.HAR != nil {
+		client.Transport = opts.HAR.transport(client.Transport)
+	}
+	return &client
+}
+
+func (o Options) newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// The default of 2 idle connections per host would make most workers
+	// reconnect for every chunk
+	transport.MaxIdleConnsPerHost = o.Concurrency
+	transport.MaxConnsPerHost = o.MaxConnsPerHost
+	if o.Proxy != nil {
+		transport.Proxy = http.ProxyURL(o.Proxy)
+	}
+	if o.TLSConfig != nil {
+		transport.TLSClientConfig = o.TLSConfig.Clone()
+	}
+	if o.DialContext != nil {
+		transport.DialContext = o.DialContext
+	}
+	if o.DisableHTTP2 {
+		// A non-nil empty map keeps net/http from adding HTTP/2 itself
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.NextProtos = []string{"http/1.1"}
+	}
+	return transport
+}
+
+// UnixSocketDialer returns an Options.DialContext connecting to the unix
+// socket at path whatever address is asked for, so the host of the URL only
+// ends up in the Host header
+func UnixSocketDialer(path string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	return func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", path)
+	}
+}
+
+// IPVersionDialer returns an Options.DialContext that only connects over
+// IPv4 for version 4 or IPv6 for version 6, for hosts where the other family
+// is broken or slow. Without one both are raced as in RFC 6555.
+func IPVersionDialer(version int) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	var suffix string
+	switch version {
+	case 4, 6:
+		suffix = fmt.Sprint(version)
+	default:
+		return nil, fmt.Errorf("unknown IP version %d, expected 4 or 6", version)
+	}
+	// Same as http.DefaultTransport
+	dialer := net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network += suffix
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}, nil
+}
+
+// ParseProxy parses a proxy URL for Options.Proxy. The scheme has to be
+// http, https or socks5.
+func ParseProxy(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", rawURL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: scheme must be http, https or socks5", rawURL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", rawURL)
+	}
+	return u, nil
+}
+
+// redirectPolicy follows up to limit redirects, as Options.MaxRedirects. It
+// keeps credentials on redirects within the original host and drops them as
+// soon as a redirect leaves it.
+func redirectPolicy(limit int, log Logger) func(req *http.Request, via []*http.Request) error {
+	if limit == 0 {
+		limit = DefaultMaxRedirects
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if limit < 0 {
+			return fmt.Errorf("redirected to %s, not following redirects", req.URL.Redacted())
+		}
+		if len(via) > limit {
+			return fmt.Errorf("stopped after %d redirects", limit)
+		}
+		log.Debug("Redirected from", via[len(via)-1].URL.Redacted(), "to", req.URL.Redacted())
+		if req.URL.Host != via[0].URL.Host {
+			req.Header.Del("Authorization")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDisableHTTP2(t *testing.T) {
+	data := testData(300000)
+	var mu sync.Mutex
+	protos := make(map[string]bool)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		protos[r.Proto] = true
+		mu.Unlock()
+		serveData(data)(w, r)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	tests := []struct {
+		name    string
+		disable bool
+		want    string
+	}{
+		{name: "default", want: "HTTP/2.0"},
+		{name: "disabled", disable: true, want: "HTTP/1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clear(protos)
+			opts := testOptions()
+			opts.ChunkSize = 64 << 10
+			opts.TLSConfig = &tls.Config{RootCAs: roots}
+			opts.DisableHTTP2 = tt.disable
+			var sink MemorySink
+			if err := (&Downloader{}).DownloadAt(context.Background(), srv.URL+"/file.bin", &sink, opts); err != nil {
+				t.Fatal(err)
+			}
+			// The HEAD as well as every chunk
+			if len(protos) != 1 || !protos[tt.want] {
+				t.Errorf("requests came in over %v, want only %s", protos, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxRedirects(t *testing.T) {
+	data := testData(100000)
+	// /hop/3 redirects to /hop/2 and so on down to the file
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n, ok := strings.CutPrefix(r.URL.Path, "/hop/"); ok {
+			left, _ := strconv.Atoi(n)
+			next := "/file.bin"
+			if left > 1 {
+				next = fmt.Sprintf("/hop/%d", left-1)
+			}
+			http.Redirect(w, r, next, http.StatusFound)
+			return
+		}
+		serveData(data)(w, r)
+	}))
+	tests := []struct {
+		name  string
+		hops  int
+		limit int
+		err   bool
+	}{
+		{name: "default", hops: 10},
+		{name: "over the default", hops: 11, err: true},
+		{name: "within the limit", hops: 3, limit: 3},
+		{name: "over the limit", hops: 3, limit: 2, err: true},
+		{name: "disabled", hops: 1, limit: -1, err: true},
+		{name: "disabled without redirects", limit: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := testOptions()
+			opts.MaxRedirects = tt.limit
+			url := srv.URL + "/file.bin"
+			if tt.hops > 0 {
+				url = fmt.Sprintf("%s/hop/%d", srv.URL, tt.hops)
+			}
+			d := &Downloader{Client: srv.Client()}
+			dest := filepath.Join(t.TempDir(), "file.bin")
+			err := d.Download(context.Background(), url, dest, opts)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+				t.Error("download doesn't match the served file")
+			}
+		})
+	}
+}
+
+func TestRedirectPolicyWithClientCheckRedirect(t *testing.T) {
+	data := testData(100000)
+	var mu sync.Mutex
+	var leaked bool
+	other := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		leaked = leaked || r.Header.Get("Authorization") != ""
+		mu.Unlock()
+		serveData(data)(w, r)
+	}))
+	srv := newServer(t, http.RedirectHandler(other.URL+"/file.bin", http.StatusFound))
+
+	// The caller's own policy must not stop ours from running
+	var checked int
+	client := *srv.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		mu.Lock()
+		checked++
+		mu.Unlock()
+		return nil
+	}
+	opts := testOptions()
+	opts.Auth = Auth{Bearer: "secret"}
+	d := &Downloader{Client: &client}
+	dest := filepath.Join(t.TempDir(), "file.bin")
+	if err := d.Download(context.Background(), srv.URL+"/file.bin", dest, opts); err != nil {
+		t.Fatal(err)
+	}
+	if leaked {
+		t.Error("Authorization was sent to the other host")
+	}
+	if checked == 0 {
+		t.Error("the client's CheckRedirect was never called")
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+
+	opts.MaxRedirects = -1
+	if err := d.Download(context.Background(), srv.URL+"/file.bin", filepath.Join(t.TempDir(), "file.bin"), opts); err == nil {
+		t.Error("followed a redirect with redirects disabled")
+	}
+}
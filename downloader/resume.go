@@ -0,0 +1,298 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Range of bytes, both ends inclusive (same as the Range header)
+type chunkRange struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// doneRange is a range on disk along with the validator of the remote file
+// it was fetched under
+type doneRange struct {
+	chunkRange
+	Validator string `json:"validator,omitempty"`
+}
+
+// sidecarVersion is bumped whenever the sidecar format changes. Version 1
+// had no version field and a single validator for the whole file.
+const sidecarVersion = 2
+
+// resumeState is persisted next to the target file (name.part) and records
+// which ranges are already on disk so a rerun can skip them.
+type resumeState struct {
+	mu   sync.Mutex
+	path string
+	// Validator of the remote file now, stamped on every new range
+	validator string
+
+	Version int    `json:"version"`
+	Size    uint64 `json:"size"`
+	// Start of Options.Range in the remote file
+	Offset uint64      `json:"offset,omitempty"`
+	Done   []doneRange `json:"done"`
+}
+
+// v1State is the part of a version 1 sidecar that differs from version 2
+type v1State struct {
+	ETag         string       `json:"etag"`
+	LastModified string       `json:"last_modified"`
+	Done         []chunkRange `json:"done"`
+}
+
+// tempName is where the data for name is written until the download is
+// complete
+func tempName(name string) string {
+	return name + ".tmp"
+}
+
+func sidecarName(name string) string {
+	return name + ".part"
+}
+
+func newResumeState(name string, plan *Plan) *resumeState {
+	state := &resumeState{path: sidecarName(name), Version: sidecarVersion}
+	state.bind(plan)
+	return state
+}
+
+// bind must be called with s.mu held unless s is new
+func (s *resumeState) bind(plan *Plan) {
+	s.Size = plan.Size
+	s.Offset = 0
+	if plan.Range != nil {
+		s.Offset = plan.Range.Start
+	}
+	s.validator = plan.Remote.validator()
+}
+
+// loadResumeState reads the sidecar for name; found reports whether there
+// was one, i.e. whether name is a partial download of ours. Nothing is kept
+// unless the sidecar was written for a file of the same size and range.
+// Each completed range is then checked on its own: it is kept if it was
+// fetched under the validator the remote file has now, which is exactly
+// what an If-Range request for it would answer, and fetched again
+// otherwise. With strict set, a sidecar that can't be used in full is an
+// ErrCantResume instead.
+func loadResumeState(name string, plan *Plan, strict bool, logger Logger) (state *resumeState, found bool, err error) {
+	state = newResumeState(name, plan)
+
+	data, err := os.ReadFile(state.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var saved resumeState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		if strict {
+			return nil, true, fmt.Errorf("%w: unreadable %s: %v", ErrCantResume, state.path, err)
+		}
+		logger.Info("Ignoring unreadable", state.path, "-", err)
+		return state, true, nil
+	}
+	switch {
+	case saved.Version > sidecarVersion:
+		if strict {
+			return nil, true, fmt.Errorf("%w: %s was written by a newer version", ErrCantResume, state.path)
+		}
+		logger.Info("Ignoring", state.path, "written by a newer version")
+		return state, true, nil
+	case saved.Version < 2:
+		if err := upgradeV1(data, &saved); err != nil {
+			if strict {
+				return nil, true, fmt.Errorf("%w: unreadable %s: %v", ErrCantResume, state.path, err)
+			}
+			logger.Info("Ignoring unreadable", state.path, "-", err)
+			return state, true, nil
+		}
+	}
+	if saved.Size != state.Size || saved.Offset != state.Offset {
+		if strict {
+			return nil, true, fmt.Errorf("%w: %s is for a %d byte file, the remote one is %d bytes", ErrCantResume, state.path, saved.Size, state.Size)
+		}
+		logger.Info("Remote file changed since the last run, starting over")
+		return state, true, nil
+	}
+
+	var stale uint64
+	for _, r := range saved.Done {
+		if r.Validator != state.validator {
+			stale += r.End - r.Start + 1
+			continue
+		}
+		state.Done = append(state.Done, r)
+	}
+	if stale > 0 && strict {
+		return nil, true, fmt.Errorf("%w: remote file changed since the last run, %d bytes were fetched under another validator", ErrCantResume, stale)
+	}
+	if stale > 0 {
+		logger.Info("Remote file changed since the last run,", stale, "bytes have to be fetched again")
+	}
+	return state, true, nil
+}
+
+// upgradeV1 fills in the ranges of a version 1 sidecar, giving each the
+// validator that was recorded for the whole file
+func upgradeV1(data []byte, s *resumeState) error {
+	var v1 v1State
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return err
+	}
+	validator := (&RemoteFile{ETag: v1.ETag, LastModified: v1.LastModified}).validator()
+	s.Done = nil
+	for _, r := range v1.Done {
+		s.Done = append(s.Done, doneRange{chunkRange: r, Validator: validator})
+	}
+	return nil
+}
+
+// Missing returns the ranges of the file not covered by Done
+func (s *resumeState) Missing() []chunkRange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var gaps []chunkRange
+	var next uint64
+	for _, r := range s.Done {
+		if r.Start > next {
+			gaps = append(gaps, chunkRange{Start: next, End: r.Start - 1})
+		}
+		next = max(next, r.End+1)
+	}
+	if next < s.Size {
+		gaps = append(gaps, chunkRange{Start: next, End: s.Size - 1})
+	}
+	return gaps
+}
+
+func (s *resumeState) Downloaded() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total uint64
+	for _, r := range s.Done {
+		total += r.End - r.Start + 1
+	}
+	return total
+}
+
+func (s *resumeState) MarkDone(start, end uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Done = mergeRanges(append(s.Done, doneRange{chunkRange{Start: start, End: end}, s.validator}))
+	return s.save()
+}
+
+// mergeRanges sorts ranges and coalesces the ones that touch or overlap and
+// share a validator
+func mergeRanges(ranges []doneRange) []doneRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.Start <= merged[n-1].End+1 && r.Validator == merged[n-1].Validator {
+			if r.End > merged[n-1].End {
+				merged[n-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// Reset forgets every completed range and rebinds the state to plan
+func (s *resumeState) Reset(plan *Plan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bind(plan)
+	s.Done = nil
+	s.skip(plan)
+}
+
+// Skip counts the bytes outside plan.Ranges as done, so they are never
+// fetched and stay holes
+func (s *resumeState) Skip(plan *Plan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skip(plan)
+}
+
+// skip must be called with s.mu held
+func (s *resumeState) skip(plan *Plan) {
+	if len(plan.Ranges) == 0 {
+		return
+	}
+	var next uint64
+	for _, r := range plan.Ranges {
+		if r.Start > next {
+			s.Done = append(s.Done, doneRange{chunkRange{Start: next, End: r.Start - 1}, s.validator})
+		}
+		next = r.End + 1
+	}
+	if next < s.Size {
+		s.Done = append(s.Done, doneRange{chunkRange{Start: next, End: s.Size - 1}, s.validator})
+	}
+	s.Done = mergeRanges(s.Done)
+}
+
+// Shrink cuts the file down to size, forgetting any completed range past it
+func (s *resumeState) Shrink(size uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Size = size
+	kept := s.Done[:0]
+	for _, r := range s.Done {
+		if r.Start >= size {
+			continue
+		}
+		r.End = min(r.End, size-1)
+		kept = append(kept, r)
+	}
+	s.Done = kept
+}
+
+func (s *resumeState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+// save must be called with s.mu held. The sidecar is replaced via rename so a
+// crash mid-write never leaves a truncated state behind. A state without a
+// path only lives in memory.
+func (s *resumeState) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0664); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *resumeState) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return nil
+	}
+	err := os.Remove(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,230 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+var (
+	errRangeIgnored    = errors.New("server ignored the Range header")
+	errResourceChanged = errors.New("remote file changed")
+)
+
+type shortChunkError struct {
+	Expected, Got int64
+}
+
+func (e *shortChunkError) Error() string {
+	return fmt.Sprintf("expected %d bytes, got %d", e.Expected, e.Got)
+}
+
+// longChunkError is returned as soon as a response goes on past the bytes
+// that were asked for
+type longChunkError struct {
+	Expected int64
+}
+
+func (e *longChunkError) Error() string {
+	return fmt.Sprintf("expected %d bytes, got more", e.Expected)
+}
+
+// chunkWriter passes on at most left bytes and fails the write that would
+// go past them
+type chunkWriter struct {
+	w        io.Writer
+	left     int64
+	expected int64
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= c.left {
+		n, err := c.w.Write(p)
+		c.left -= int64(n)
+		return n, err
+	}
+	n, err := c.w.Write(p[:c.left])
+	c.left -= int64(n)
+	if err == nil {
+		err = &longChunkError{Expected: c.expected}
+	}
+	return n, err
+}
+
+// retryable reports whether err is worth another attempt. Server errors,
+// 429 and transport failures (resets, timeouts, early EOF) are; other client
+// errors and local write failures are not.
+func retryable(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusTooManyRequests
+	}
+	// FTP replies: 4xx are transient, 5xx permanent
+	var ftpErr *textproto.Error
+	if errors.As(err, &ftpErr) {
+		return ftpErr.Code < 500
+	}
+	if errors.Is(err, errRangeIgnored) || errors.Is(err, errResourceChanged) {
+		return false
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return false
+	}
+	var tooLarge *SizeLimitError
+	if errors.As(err, &tooLarge) {
+		return false
+	}
+	var long *longChunkError
+	if errors.As(err, &long) {
+		return false
+	}
+	return true
+}
+
+// backoff returns the delay before the given retry attempt (starting at 1):
+// base doubled every attempt, capped at max, plus up to 50% random jitter.
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	delay := base << (attempt - 1)
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+	if delay >= 2 {
+		delay += time.Duration(rand.Int63n(int64(delay / 2)))
+	}
+	return delay
+}
+
+// jitter returns d give or take up to half of it
+func jitter(d time.Duration) time.Duration {
+	if d < 2 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// retryDelay is how long to wait before the given retry after err, what the
+// server asked for in Retry-After or else the backoff, at most
+// w.maxRetryDelay either way
+func (w *worker) retryDelay(err error, attempt int) time.Duration {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > w.maxRetryDelay {
+			w.log.Info("Server asked to wait", statusErr.RetryAfter, "- waiting", w.maxRetryDelay, "instead")
+			return w.maxRetryDelay
+		}
+		return statusErr.RetryAfter
+	}
+	return backoff(w.retryBaseDelay, w.maxRetryDelay, attempt)
+}
+
+// sleep waits for d or until ctx is done, whichever comes first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// buffered points the worker's write buffer at dst, dropping whatever a
+// failed attempt left in it
+func (w *worker) buffered(dst io.Writer) *bufio.Writer {
+	if w.buf == nil {
+		w.buf = bufio.NewWriterSize(dst, w.writeBuffer)
+	} else {
+		w.buf.Reset(dst)
+	}
+	return w.buf
+}
+
+// fetchWithRetry writes r into location at r.start and checks that exactly
+// r.length bytes arrived (unless it is negative), or at most that many if
+// r.last is set. Every attempt starts writing at r.start again, so whatever
+// a failed attempt managed to write is overwritten by the next one.
+func (w *worker) fetchWithRetry(ctx context.Context, p protocol, r rangeRequest, location io.WriterAt) (int64, error) {
+	var n int64
+	var err error
+	var block Block
+	verify := false
+	if len(w.blocks) > 0 && r.length > 0 {
+		block, verify = blockAt(w.blocks, uint64(r.start), uint64(r.length))
+	}
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			delay := w.retryDelay(err, attempt)
+			w.log.Info("Retrying", r.url, "in", delay, "-", err)
+			if err := sleep(ctx, delay); err != nil {
+				return 0, err
+			}
+			w.retryWait += delay
+		}
+		w.attempts++
+		var dst io.Writer = io.NewOffsetWriter(location, r.start)
+		var h hash.Hash
+		if verify {
+			h, _ = block.Checksum.newHash()
+			dst = io.MultiWriter(dst, h)
+		}
+		if w.writeBuffer > 0 {
+			dst = w.buffered(dst)
+		}
+		if w.limiter != nil {
+			dst = &limitedWriter{ctx: ctx, limiter: w.limiter, turn: w.limiterTurn, w: dst}
+		}
+		getCtx, stop := ctx, func() {}
+		if w.stallSpeed > 0 {
+			dog := &watchdog{w: dst}
+			dst = dog
+			getCtx, stop = dog.watch(ctx, w.stallSpeed, w.stallWindow)
+		}
+		// Nothing past the planned length gets written or counted
+		if r.length >= 0 {
+			dst = &chunkWriter{w: dst, left: r.length, expected: r.length}
+		}
+		counter := &countingWriter{w: dst, report: w.progress}
+		n, err = p.get(getCtx, r, counter)
+		if err != nil && context.Cause(getCtx) == errStalled {
+			err = fmt.Errorf("%w, under %d bytes/s for %v", errStalled, w.stallSpeed, w.stallWindow)
+		}
+		stop()
+		if w.writeBuffer > 0 {
+			if flushErr := w.buf.Flush(); err == nil {
+				err = flushErr
+			}
+		}
+		// The file ends before the advertised size, somewhere in this
+		// chunk or right at its start
+		if r.last && (err == nil && n < r.length || errors.Is(err, ErrRangeNotSatisfiable)) {
+			return n, nil
+		}
+		if err == nil && r.length >= 0 && n != r.length {
+			err = &shortChunkError{Expected: r.length, Got: n}
+		}
+		if err == nil && verify {
+			if err = block.Checksum.check(h.Sum(nil)); err != nil {
+				err = fmt.Errorf("bytes %d-%d: %w", block.Offset, block.End(), err)
+			}
+		}
+		if err != nil && counter.n > 0 && w.progress != nil {
+			w.progress(-int(counter.n))
+		}
+		// Client timeouts look like context errors too, so only give up if
+		// it is the caller's context that is done
+		if err == nil || !retryable(err) || ctx.Err() != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,250 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{" 3 ", 3 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+		want      time.Duration
+		clamped   bool
+	}{
+		{name: "429 with Retry-After", err: &HTTPStatusError{Code: 429, RetryAfter: 7 * time.Second}, retryable: true, want: 7 * time.Second},
+		{name: "503 with Retry-After", err: &HTTPStatusError{Code: 503, RetryAfter: time.Minute}, retryable: true, want: time.Minute},
+		{name: "Retry-After over the maximum", err: &HTTPStatusError{Code: 503, RetryAfter: 24 * time.Hour}, retryable: true, want: time.Hour, clamped: true},
+		{name: "429 without", err: &HTTPStatusError{Code: 429}, retryable: true},
+		{name: "404", err: &HTTPStatusError{Code: 404}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.retryable {
+				t.Errorf("retryable = %v, want %v", got, tt.retryable)
+			}
+			var logs bytes.Buffer
+			w := &worker{
+				log:            NewLogger(log.New(&logs, "", 0), LevelInfo),
+				retryBaseDelay: time.Millisecond,
+				maxRetryDelay:  time.Hour,
+			}
+			got := w.retryDelay(tt.err, 1)
+			if tt.want != 0 && got != tt.want {
+				t.Errorf("delay = %v, want %v", got, tt.want)
+			}
+			// Otherwise the backoff of up to 1.5 times the base
+			if tt.want == 0 && got > 2*time.Millisecond {
+				t.Errorf("delay = %v, want the backoff", got)
+			}
+			if logged := logs.Len() > 0; logged != tt.clamped {
+				t.Errorf("logged %q, want a message only when clamped", logs.String())
+			}
+		})
+	}
+}
+
+func TestDownloadHonorsRetryAfter(t *testing.T) {
+	data := testData(100000)
+	var refused atomic.Bool
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && !refused.Swap(true) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		serveData(data)(w, r)
+	}))
+
+	opts := testOptions()
+	opts.Concurrency = 1
+	start := time.Now()
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, the server asked for 1s", elapsed)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+}
+
+func TestDownloadRestartsStalledChunk(t *testing.T) {
+	data := testData(100000)
+	var trickled atomic.Bool
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || trickled.Swap(true) {
+			serveData(data)(w, r)
+			return
+		}
+		// A few bytes at a time until the client gives up
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		for i := 0; i < len(data); i += 10 {
+			if _, err := w.Write(data[i : i+10]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+
+	opts := testOptions()
+	opts.Concurrency = 1
+	opts.StallSpeed = 10 << 10
+	opts.StallWindow = 100 * time.Millisecond
+	start := time.Now()
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < opts.StallWindow || elapsed > 5*time.Second {
+		t.Errorf("took %v, want the stalled request cut off after %v", elapsed, opts.StallWindow)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+}
+
+func TestWatchdogKeepsSteadyTransfer(t *testing.T) {
+	dog := &watchdog{w: io.Discard}
+	ctx, stop := dog.watch(context.Background(), 1000, 20*time.Millisecond)
+	defer stop()
+	// 100 bytes every 5ms is 20000 bytes/s
+	for i := 0; i < 20; i++ {
+		dog.Write(make([]byte, 100))
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := context.Cause(ctx); err != nil {
+		t.Errorf("steady transfer was stopped with %v", err)
+	}
+}
+
+func TestDownloadRequestDelay(t *testing.T) {
+	data := testData(5 * MinChunkSize)
+	var mu sync.Mutex
+	var times []time.Time
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			times = append(times, time.Now())
+			mu.Unlock()
+		}
+		serveData(data)(w, r)
+	}))
+
+	opts := testOptions()
+	opts.Concurrency = 1
+	opts.ChunkSize = MinChunkSize
+	opts.RequestDelay = 40 * time.Millisecond
+	got, err := download(t, srv, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("download doesn't match the served file")
+	}
+	if len(times) != 5 {
+		t.Fatalf("got %d requests, want 5", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < opts.RequestDelay/2 {
+			t.Errorf("request %d came %v after the previous one", i, gap)
+		}
+	}
+}
+
+func TestRequestDelayCancel(t *testing.T) {
+	data := testData(2 * MinChunkSize)
+	srv := newServer(t, serveData(data))
+	opts := testOptions()
+	opts.Concurrency = 1
+	opts.ChunkSize = MinChunkSize
+	opts.RequestDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	d := &Downloader{Client: srv.Client()}
+	start := time.Now()
+	err := d.Download(ctx, srv.URL+"/file.bin", filepath.Join(t.TempDir(), "file.bin"), opts)
+	if err == nil {
+		t.Fatal("download finished despite the delay")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled download took %v to return", elapsed)
+	}
+}
+
+func TestChunkStatsRetryWait(t *testing.T) {
+	data := testData(100000)
+	var failed atomic.Bool
+	srv := newServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && !failed.Swap(true) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		serveData(data)(w, r)
+	}))
+
+	status := make(chan Status, 100)
+	opts := testOptions()
+	opts.Concurrency = 1
+	opts.RetryBaseDelay = 20 * time.Millisecond
+	opts.Status = status
+	if _, err := download(t, srv, opts); err != nil {
+		t.Fatal(err)
+	}
+	close(status)
+	var chunks int
+	for s := range status {
+		if s.Chunk == nil {
+			continue
+		}
+		chunks++
+		if s.Chunk.Attempts != 2 || s.Chunk.RetryWait < opts.RetryBaseDelay {
+			t.Errorf("chunk made %d attempts, waiting %v, want 2 and at least %v", s.Chunk.Attempts, s.Chunk.RetryWait, opts.RetryBaseDelay)
+		}
+	}
+	if chunks != 1 {
+		t.Errorf("got stats for %d chunks, want 1", chunks)
+	}
+}
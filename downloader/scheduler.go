@@ -0,0 +1,84 @@
+package downloader
+
+import "sync"
+
+// minTailChunk is the smallest piece the end of a download is cut into
+const minTailChunk = 256 << 10
+
+// chunkQueue hands out the ranges still missing from a file. Chunks are
+// chunkSize long until what is left no longer gives every worker a full one,
+// then they shrink so the workers finish together instead of all but one
+// idling while the last big chunk trickles in. Every chunk but the last of a
+// gap ends on a multiple of align. With blocks every chunk is a block, or
+// what of one is missing, whatever the chunk size. After segment the chunks
+// keep their size to the end.
+type chunkQueue struct {
+	mu        sync.Mutex
+	gaps      []chunkRange
+	chunkSize uint64
+	align     uint64
+	workers   uint64
+	remaining uint64
+	handed    int
+	blocks    []Block
+	fixed     bool
+}
+
+func newChunkQueue(gaps []chunkRange, chunkSize, align uint64, workers int) *chunkQueue {
+	q := &chunkQueue{gaps: gaps, chunkSize: chunkSize, align: max(align, 1), workers: uint64(max(workers, 1))}
+	for _, g := range gaps {
+		q.remaining += g.End - g.Start + 1
+	}
+	return q
+}
+
+// segment cuts what is missing into n chunks of the same size, give or take
+// the alignment and the boundaries of the gaps
+func (q *chunkQueue) segment(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	size := (q.remaining + uint64(n) - 1) / uint64(n)
+	q.chunkSize = max((size+q.align-1)/q.align*q.align, 1)
+	q.fixed = true
+}
+
+// empty reports whether every chunk has been handed out
+func (q *chunkQueue) empty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.gaps) == 0
+}
+
+// next returns the next inclusive range to fetch and its index in the order
+// chunks were handed out, ok is false once there is nothing left
+func (q *chunkQueue) next() (index int, start, end uint64, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.gaps) == 0 {
+		return 0, 0, 0, false
+	}
+
+	size := q.chunkSize
+	if share := q.remaining / q.workers; share < size && !q.fixed {
+		size = max(share, min(minTailChunk, q.chunkSize))
+	}
+	gap := &q.gaps[0]
+	start = gap.Start
+	// Round down to the alignment, or up if that leaves nothing
+	stop := (start + size) / q.align * q.align
+	if stop <= start {
+		stop = (start/q.align + 1) * q.align
+	}
+	end = min(stop-1, gap.End)
+	if edge, ok := blockEnd(q.blocks, start); ok {
+		end = min(edge, gap.End)
+	}
+	if end == gap.End {
+		q.gaps = q.gaps[1:]
+	} else {
+		gap.Start = end + 1
+	}
+	q.remaining -= end - start + 1
+	q.handed++
+	return q.handed - 1, start, end, true
+}
@@ -0,0 +1,103 @@
+package downloader
+
+import (
+	"slices"
+	"testing"
+)
+
+// drain takes every chunk from q and checks that together they cover gaps
+// exactly, in order. The queue cuts down the gaps it is given, so it needs a
+// copy of them.
+func drain(t *testing.T, q *chunkQueue, gaps []chunkRange) []chunkRange {
+	t.Helper()
+	var chunks []chunkRange
+	for {
+		_, start, end, ok := q.next()
+		if !ok {
+			break
+		}
+		chunks = append(chunks, chunkRange{Start: start, End: end})
+	}
+
+	i := 0
+	for _, g := range gaps {
+		next := g.Start
+		for ; i < len(chunks) && chunks[i].End <= g.End; i++ {
+			if chunks[i].Start != next || chunks[i].End < chunks[i].Start {
+				t.Fatalf("chunk %d is %v, expected it to start at %d", i, chunks[i], next)
+			}
+			next = chunks[i].End + 1
+		}
+		if next != g.End+1 {
+			t.Fatalf("gap %v is only covered up to %d", g, next)
+		}
+	}
+	if i != len(chunks) {
+		t.Fatalf("%d chunks beyond the gaps", len(chunks)-i)
+	}
+	return chunks
+}
+
+func TestChunkQueueCoversGaps(t *testing.T) {
+	gaps := []chunkRange{{0, 999}, {5000, 5000}, {10000, 2<<20 + 17}}
+	chunks := drain(t, newChunkQueue(slices.Clone(gaps), 64<<10, 0, 4), gaps)
+	for _, c := range chunks {
+		if c.End-c.Start+1 > 64<<10 {
+			t.Errorf("chunk %v is larger than the chunk size", c)
+		}
+	}
+}
+
+func TestChunkQueueShrinksTail(t *testing.T) {
+	gaps := []chunkRange{{0, 10<<20 - 1}}
+	chunks := drain(t, newChunkQueue(slices.Clone(gaps), 4<<20, 0, 4), gaps)
+	last := chunks[len(chunks)-1]
+	if size := last.End - last.Start + 1; size >= 4<<20 {
+		t.Errorf("last chunk is %d bytes, expected the tail to be split up", size)
+	}
+}
+
+func TestChunkQueueAlign(t *testing.T) {
+	const align = 4096
+	gaps := []chunkRange{{100, 3<<20 + 5}}
+	chunks := drain(t, newChunkQueue(slices.Clone(gaps), 256<<10, align, 3), gaps)
+	for i, c := range chunks[:len(chunks)-1] {
+		if (c.End+1)%align != 0 {
+			t.Errorf("chunk %d ends at %d, not before a multiple of %d", i, c.End, align)
+		}
+	}
+}
+
+func TestChunkQueueBlocks(t *testing.T) {
+	blocks := []Block{{Offset: 0, Length: 1000}, {Offset: 1000, Length: 3000}, {Offset: 6000, Length: 500}}
+	gaps := []chunkRange{{0, 7999}}
+	q := newChunkQueue(slices.Clone(gaps), 64<<10, 0, 4)
+	q.blocks = blocks
+	chunks := drain(t, q, gaps)
+	// Blocks whatever the chunk size, and the holes between them as they are
+	want := []chunkRange{{0, 999}, {1000, 3999}, {4000, 5999}, {6000, 6499}, {6500, 7999}}
+	if !slices.Equal(chunks, want) {
+		t.Errorf("got chunks %v, want %v", chunks, want)
+	}
+}
+
+func TestChunkQueueSegments(t *testing.T) {
+	tests := []struct {
+		name string
+		gaps []chunkRange
+		want []chunkRange
+	}{
+		{name: "even", gaps: []chunkRange{{0, 12<<20 - 1}}, want: []chunkRange{{0, 4<<20 - 1}, {4 << 20, 8<<20 - 1}, {8 << 20, 12<<20 - 1}}},
+		{name: "uneven", gaps: []chunkRange{{0, 9}}, want: []chunkRange{{0, 3}, {4, 7}, {8, 9}}},
+		{name: "resumed", gaps: []chunkRange{{0, 99}, {1000, 1199}}, want: []chunkRange{{0, 99}, {1000, 1099}, {1100, 1199}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newChunkQueue(slices.Clone(tt.gaps), 64<<10, 0, 3)
+			q.segment(3)
+			if chunks := drain(t, q, tt.gaps); !slices.Equal(chunks, tt.want) {
+				t.Errorf("got chunks %v, want %v", chunks, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// Sink is where DownloadAt puts the file. WriteAt is called from all workers
+// at once, each writing its own chunk, so it has to be safe for concurrent
+// use at disjoint offsets. An *os.File is a Sink. Writers that only take
+// bytes in order, such as pipes, go to DownloadTo instead.
+type Sink interface {
+	WriteAt(p []byte, off int64) (int, error)
+	// Truncate is called with the size of the file before the first write
+	Truncate(size int64) error
+}
+
+var _ Sink = (*os.File)(nil)
+
+// MemorySink keeps the whole file in memory, e.g. for tests or small files
+type MemorySink struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (m *MemorySink) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if end := int(off) + len(p); end > len(m.buf) {
+		m.buf = append(m.buf, make([]byte, end-len(m.buf))...)
+	}
+	return copy(m.buf[off:], p), nil
+}
+
+func (m *MemorySink) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if int(size) <= len(m.buf) {
+		m.buf = m.buf[:size]
+		return nil
+	}
+	m.buf = append(m.buf, make([]byte, int(size)-len(m.buf))...)
+	return nil
+}
+
+// Bytes returns what was written so far. It must not be called while a
+// download into m is running.
+func (m *MemorySink) Bytes() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.buf
+}
+
+// offsetSink shifts every write by offset and leaves the size of w alone
+type offsetSink struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (s *offsetSink) WriteAt(p []byte, off int64) (int, error) {
+	return s.w.WriteAt(p, s.offset+off)
+}
+
+func (s *offsetSink) Truncate(int64) error {
+	return nil
+}
+
+// DownloadAtOffset is DownloadAt into w with the first byte of the file
+// going to offset, e.g. to fill in part of an image or archive. w is never
+// truncated, whatever was there before and after the file stays.
+func (d *Downloader) DownloadAtOffset(ctx context.Context, url string, w io.WriterAt, offset int64, opts Options) error {
+	if offset < 0 {
+		return errors.New("negative offset")
+	}
+	return d.DownloadAt(ctx, url, &offsetSink{w: w, offset: offset}, opts)
+}
+
+// DownloadAt fetches url into sink using the same concurrent ranged requests
+// as Download. Nothing is kept on disk, so an interrupted DownloadAt can't be
+// resumed, and opts.Checksum, OutputDir and OnComplete are ignored.
+func (d *Downloader) DownloadAt(ctx context.Context, url string, sink Sink, opts Options) error {
+	opts = opts.withDefaults()
+	client := d.httpClient(opts)
+
+	plan, err := d.plan(ctx, client, append([]string{url}, opts.Mirrors...), "", opts)
+	if err != nil {
+		return err
+	}
+	if plan.Remote.UnknownSize {
+		opts.Logger.Info("Size unknown, downloading as a single stream")
+		var file io.WriterAt = sink
+		if opts.MaxSize > 0 {
+			file = &cappedWriterAt{w: sink, limit: opts.MaxSize}
+		}
+		opts.report(Status{})
+		defer opts.running(0)()
+		_, err := newWorker(client, plan, opts).fetchRange(ctx, plan, opts, file, 0, -1, false)
+		return err
+	}
+
+	if err := sink.Truncate(int64(plan.Size)); err != nil {
+		return err
+	}
+	if plan.Size == 0 {
+		opts.report(Status{})
+		return nil
+	}
+	state := &resumeState{}
+	state.Reset(plan)
+	opts.report(Status{Total: int(plan.Size)})
+	if err := d.fetchChunks(ctx, client, plan, opts, state, sink); err != nil {
+		return err
+	}
+	if downloaded := state.Downloaded(); downloaded != plan.Size {
+		return &IncompleteError{Downloaded: downloaded, Size: plan.Size}
+	}
+	// The file may have turned out shorter than announced
+	return sink.Truncate(int64(plan.Size))
+}
@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestDownloadAtMemorySink(t *testing.T) {
+	data := testData(1<<20 + 7)
+	srv := newServer(t, serveData(data))
+	d := &Downloader{Client: srv.Client()}
+
+	var sink MemorySink
+	opts := testOptions()
+	opts.ChunkSize = 64 << 10
+	if err := d.DownloadAt(context.Background(), srv.URL+"/file.bin", &sink, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sink.Bytes(), data) {
+		t.Error("sink doesn't hold the served file")
+	}
+}
+
+func TestMemorySinkWriteAt(t *testing.T) {
+	var sink MemorySink
+	sink.WriteAt([]byte("world"), 6)
+	sink.WriteAt([]byte("hello "), 0)
+	if got := string(sink.Bytes()); got != "hello world" {
+		t.Errorf("got %q", got)
+	}
+	sink.Truncate(5)
+	if got := string(sink.Bytes()); got != "hello" {
+		t.Errorf("got %q after Truncate", got)
+	}
+	if _, err := sink.WriteAt([]byte("x"), -1); err == nil {
+		t.Error("negative offset accepted")
+	}
+}
+
+func TestDownloadAtOffset(t *testing.T) {
+	data := testData(300000)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{name: "ranged", handler: serveData(data)},
+		{
+			name: "no ranges",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+				if r.Method == http.MethodGet {
+					w.Write(data)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t, tt.handler)
+			d := &Downloader{Client: srv.Client()}
+			var sink MemorySink
+			sink.WriteAt([]byte("header"), 0)
+			sink.WriteAt([]byte("trailer"), int64(6+len(data)))
+
+			opts := testOptions()
+			opts.ChunkSize = 64 << 10
+			if err := d.DownloadAtOffset(context.Background(), srv.URL+"/file.bin", &sink, 6, opts); err != nil {
+				t.Fatal(err)
+			}
+			want := append(append([]byte("header"), data...), "trailer"...)
+			if !bytes.Equal(sink.Bytes(), want) {
+				t.Error("file didn't land between the header and trailer")
+			}
+		})
+	}
+}
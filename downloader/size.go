@@ -0,0 +1,39 @@
+package downloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var sizeSuffixes = []struct {
+	suffix string
+	mult   uint64
+}{
+	{"tib", 1 << 40}, {"tb", 1 << 40}, {"t", 1 << 40},
+	{"gib", 1 << 30}, {"gb", 1 << 30}, {"g", 1 << 30},
+	{"mib", 1 << 20}, {"mb", 1 << 20}, {"m", 1 << 20},
+	{"kib", 1 << 10}, {"kb", 1 << 10}, {"k", 1 << 10},
+	{"b", 1},
+}
+
+// ParseSize parses a human readable byte count such as "512K", "5MB" or
+// "1GiB". Suffixes are powers of 1024 and case insensitive, a bare number is
+// taken as bytes.
+func ParseSize(s string) (uint64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	mult := uint64(1)
+	for _, suf := range sizeSuffixes {
+		if strings.HasSuffix(str, suf.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, suf.suffix))
+			mult = suf.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(n * float64(mult)), nil
+}
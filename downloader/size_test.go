@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+		err  bool
+	}{
+		{in: "0", want: 0},
+		{in: "512", want: 512},
+		{in: "512K", want: 512 << 10},
+		{in: "5MB", want: 5 << 20},
+		{in: "1GiB", want: 1 << 30},
+		{in: " 2 t ", want: 2 << 40},
+		{in: "1.5k", want: 1536},
+		{in: "10b", want: 10},
+		{in: "", err: true},
+		{in: "-1M", err: true},
+		{in: "lots", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseSize(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ByteRange
+		err  bool
+	}{
+		{in: "0-1023", want: ByteRange{0, 1023}},
+		{in: "1M-2M", want: ByteRange{1 << 20, 2 << 20}},
+		{in: "5-5", want: ByteRange{5, 5}},
+		{in: "10-5", err: true},
+		{in: "100", err: true},
+		{in: "a-b", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteRange(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseByteRange(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseByteRange(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseByteRanges(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []ByteRange
+		err  bool
+	}{
+		{in: "0-99", want: []ByteRange{{0, 99}}},
+		{in: "5000-5999, 0-99", want: []ByteRange{{0, 99}, {5000, 5999}}},
+		{in: "0-99,50-199,200-299", want: []ByteRange{{0, 299}}},
+		{in: "0-999,10-20", want: []ByteRange{{0, 999}}},
+		{in: "0-99,", err: true},
+		{in: "0-99,20-10", err: true},
+	}
+	for _, tt := range tests {
+		ranges, err := ParseByteRanges(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseByteRanges(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got := mergeByteRanges(ranges); !slices.Equal(got, tt.want) {
+			t.Errorf("ParseByteRanges(%q) merged = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// spaceMargin is kept free on top of the file itself, for the sidecar and
+// whatever else writes to the filesystem meanwhile
+const spaceMargin = 16 << 20
+
+// InsufficientSpaceError is returned by Download when Options.CheckSpace is
+// set and the filesystem can't hold the rest of the file
+type InsufficientSpaceError struct {
+	Dir       string
+	Needed    uint64
+	Available uint64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("not enough space in %s: need %d bytes, %d available", e.Dir, e.Needed, e.Available)
+}
+
+// checkSpace makes sure the directory of dest has room for the missing
+// bytes plus spaceMargin. Platforms that can't tell are let through.
+func checkSpace(dest string, missing uint64, logger Logger) error {
+	dir := filepath.Dir(dest)
+	available, err := freeSpace(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil
+	}
+	if err != nil {
+		logger.Info("Can't check the free space in", dir, "-", err)
+		return nil
+	}
+	if needed := missing + spaceMargin; available < needed {
+		return &InsufficientSpaceError{Dir: dir, Needed: needed, Available: available}
+	}
+	return nil
+}
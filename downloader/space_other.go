@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package downloader
+
+import "errors"
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
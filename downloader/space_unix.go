@@ -0,0 +1,14 @@
+//go:build unix
+
+package downloader
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to us on the filesystem holding dir
+func freeSpace(dir string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
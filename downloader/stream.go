@@ -0,0 +1,89 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+)
+
+// DownloadTo streams url into w with a single sequential request, for
+// destinations that can't be written at arbitrary offsets such as stdout.
+// Failed attempts are only retried (or moved to a mirror) while nothing has
+// been written to w yet. A Checksum in opts is computed on the fly.
+func (d *Downloader) DownloadTo(ctx context.Context, url string, w io.Writer, opts Options) error {
+	opts = opts.withDefaults()
+	if len(opts.Ranges) > 0 {
+		return errors.New("ranges leave holes, they can't be streamed")
+	}
+	client := d.httpClient(opts)
+
+	plan, err := d.plan(ctx, client, append([]string{url}, opts.Mirrors...), "", opts)
+	if err != nil {
+		return err
+	}
+
+	var h hash.Hash
+	if opts.Checksum != nil {
+		if h, err = opts.Checksum.newHash(); err != nil {
+			return err
+		}
+		w = io.MultiWriter(w, h)
+	}
+
+	wk := newWorker(client, plan, opts)
+	if opts.MaxSize > 0 {
+		w = &cappedWriter{w: w, limit: opts.MaxSize}
+	}
+	counter := &countingWriter{w: w, report: wk.progress}
+	opts.report(Status{Total: int(plan.Size)})
+	defer opts.running(plan.Size)()
+	if err := wk.stream(ctx, plan, opts, counter); err != nil {
+		return err
+	}
+
+	if h != nil {
+		return opts.Checksum.check(h.Sum(nil))
+	}
+	return nil
+}
+
+// stream copies the whole body, or just plan.Range of it, into dst, trying
+// every mirror in turn
+func (w *worker) stream(ctx context.Context, plan *Plan, opts Options, dst *countingWriter) error {
+	var out io.Writer = dst
+	if w.limiter != nil {
+		out = &limitedWriter{ctx: ctx, limiter: w.limiter, turn: w.limiterTurn, w: dst}
+	}
+
+	var err error
+	for _, i := range plan.mirrors(plan.Range != nil, plan.fastest) {
+		rawURL := plan.URLs[i]
+		r := rangeRequest{url: rawURL, length: -1}
+		if plan.Range != nil {
+			r.start, r.length, r.ranged = int64(plan.Range.Start), int64(plan.Range.Len()), true
+		}
+		for attempt := 0; attempt <= w.retries; attempt++ {
+			if attempt > 0 {
+				delay := w.retryDelay(err, attempt)
+				w.log.Info("Retrying", rawURL, "in", delay, "-", err)
+				if err := sleep(ctx, delay); err != nil {
+					return err
+				}
+			}
+
+			_, err = newProtocol(rawURL, w.client, opts).get(ctx, r, out)
+			if err == nil || ctx.Err() != nil {
+				return err
+			}
+			// Bytes already handed to dst can't be taken back
+			if dst.n > 0 {
+				return err
+			}
+			if !retryable(err) {
+				break
+			}
+		}
+	}
+	return err
+}
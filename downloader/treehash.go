@@ -0,0 +1,181 @@
+package downloader
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"os"
+	"sync"
+)
+
+// TreeHashLeafSize is the size of the leaves of a tree-sha256 digest
+const TreeHashLeafSize = 1 << 20
+
+// TreeSHA256 is the Checksum algorithm of NewTreeHash. A Download verified
+// against it hashes chunks as they are written and only reads back the
+// leaves that didn't arrive front to back in one go.
+const TreeSHA256 = "tree-sha256"
+
+// treeHash is the SHA-256 of the SHA-256 digests of every TreeHashLeafSize
+// bytes of the input, the last leaf may be shorter
+type treeHash struct {
+	leaf hash.Hash
+	n    int
+	sums []byte
+}
+
+// NewTreeHash returns a hash.Hash computing tree-sha256 over input written
+// in order. TreeHasher computes the same digest from out of order writes.
+func NewTreeHash() hash.Hash {
+	return &treeHash{leaf: sha256.New()}
+}
+
+func (t *treeHash) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(len(p), TreeHashLeafSize-t.n)
+		t.leaf.Write(p[:n])
+		t.n += n
+		p = p[n:]
+		if t.n == TreeHashLeafSize {
+			t.sums = t.leaf.Sum(t.sums)
+			t.leaf.Reset()
+			t.n = 0
+		}
+	}
+	return written, nil
+}
+
+func (t *treeHash) Sum(b []byte) []byte {
+	sums := t.sums
+	if t.n > 0 {
+		sums = t.leaf.Sum(append([]byte(nil), sums...))
+	}
+	return treeRoot(b, sums)
+}
+
+func (t *treeHash) Reset() {
+	t.leaf.Reset()
+	t.n = 0
+	t.sums = nil
+}
+
+func (t *treeHash) Size() int      { return sha256.Size }
+func (t *treeHash) BlockSize() int { return sha256.BlockSize }
+
+// treeRoot appends the digest over the concatenated leaf digests to b
+func treeRoot(b, sums []byte) []byte {
+	root := sha256.Sum256(sums)
+	return append(b, root[:]...)
+}
+
+// TreeHasher computes tree-sha256 from writes at any offset, as the chunks
+// of a download arrive. Every leaf written front to back exactly once is
+// hashed on the fly; Sum reads back the others, those written in pieces out
+// of order, written twice by a retry or never written at all.
+type TreeHasher struct {
+	mu     sync.Mutex
+	leaves map[uint64]*treeLeaf
+}
+
+type treeLeaf struct {
+	h hash.Hash
+	// Bytes hashed so far, from the start of the leaf
+	n   uint64
+	sum []byte
+	// Has to be read back
+	dirty bool
+}
+
+func NewTreeHasher() *TreeHasher {
+	return &TreeHasher{leaves: make(map[uint64]*treeLeaf)}
+}
+
+// WriteAt hashes p as the bytes at off. It never fails.
+func (t *TreeHasher) WriteAt(p []byte, off int64) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	written := len(p)
+	for pos := uint64(off); len(p) > 0; {
+		i, inLeaf := pos/TreeHashLeafSize, pos%TreeHashLeafSize
+		n := min(uint64(len(p)), TreeHashLeafSize-inLeaf)
+		l := t.leaves[i]
+		if l == nil {
+			l = &treeLeaf{h: sha256.New()}
+			t.leaves[i] = l
+		}
+		switch {
+		case l.dirty:
+		case inLeaf != l.n:
+			l.dirty, l.h = true, nil
+		default:
+			l.h.Write(p[:n])
+			l.n += n
+			if l.n == TreeHashLeafSize {
+				l.sum, l.h = l.h.Sum(nil), nil
+			}
+		}
+		p = p[n:]
+		pos += n
+	}
+	return written, nil
+}
+
+// Tee returns an io.WriterAt writing to w and hashing what w took
+func (t *TreeHasher) Tee(w io.WriterAt) io.WriterAt {
+	return &teeWriterAt{w: w, h: t}
+}
+
+// Sum returns the tree-sha256 of the first size bytes, reading the leaves
+// that weren't hashed on the fly from r
+func (t *TreeHasher) Sum(r io.ReaderAt, size uint64) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var sums []byte
+	for i := uint64(0); i*TreeHashLeafSize < size; i++ {
+		length := min(size-i*TreeHashLeafSize, TreeHashLeafSize)
+		l := t.leaves[i]
+		switch {
+		case l != nil && !l.dirty && l.n == length && l.sum != nil:
+			sums = append(sums, l.sum...)
+		case l != nil && !l.dirty && l.n == length:
+			sums = l.h.Sum(sums)
+		default:
+			h := sha256.New()
+			if _, err := io.Copy(h, io.NewSectionReader(r, int64(i*TreeHashLeafSize), int64(length))); err != nil {
+				return nil, err
+			}
+			sums = h.Sum(sums)
+		}
+	}
+	return treeRoot(nil, sums), nil
+}
+
+// verify compares the tree-sha256 of the file at path with c
+func (t *TreeHasher) verify(path string, c *Checksum) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	sum, err := t.Sum(file, uint64(info.Size()))
+	if err != nil {
+		return err
+	}
+	return c.check(sum)
+}
+
+type teeWriterAt struct {
+	w io.WriterAt
+	h *TreeHasher
+}
+
+func (t *teeWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	n, err := t.w.WriteAt(p, off)
+	t.h.WriteAt(p[:n], off)
+	return n, err
+}
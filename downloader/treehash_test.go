@@ -0,0 +1,142 @@
+package downloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// sequentialTreeSum computes tree-sha256 the slow and obvious way
+func sequentialTreeSum(data []byte) []byte {
+	var sums []byte
+	for off := 0; off < len(data); off += TreeHashLeafSize {
+		sum := sha256.Sum256(data[off:min(off+TreeHashLeafSize, len(data))])
+		sums = append(sums, sum[:]...)
+	}
+	root := sha256.Sum256(sums)
+	return root[:]
+}
+
+// countingReaderAt counts the bytes read through it
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += n
+	return n, err
+}
+
+func TestTreeHash(t *testing.T) {
+	for _, size := range []int{0, 1000, TreeHashLeafSize, 2*TreeHashLeafSize + 12345} {
+		data := testData(size)
+		h := NewTreeHash()
+		// Pieces that don't line up with the leaves
+		for off := 0; off < len(data); off += 70000 {
+			h.Write(data[off:min(off+70000, len(data))])
+		}
+		if got, want := h.Sum(nil), sequentialTreeSum(data); !bytes.Equal(got, want) {
+			t.Errorf("%d bytes: got %x, want %x", size, got, want)
+		}
+	}
+}
+
+func TestTreeHasher(t *testing.T) {
+	data := testData(5*TreeHashLeafSize + 4321)
+	want := sequentialTreeSum(data)
+	leaf := TreeHashLeafSize
+	tests := []struct {
+		name string
+		// Written in this order, as start and end offsets
+		writes [][2]int
+		// Most bytes Sum may read back
+		reread int
+	}{
+		{name: "in order", writes: [][2]int{{0, len(data)}}},
+		{name: "leaves out of order", writes: [][2]int{{3 * leaf, len(data)}, {leaf, 3 * leaf}, {0, leaf}}},
+		{
+			// The second half of leaf 1 arrives before the first
+			name:   "leaf split out of order",
+			writes: [][2]int{{leaf + 100, len(data)}, {0, leaf + 100}},
+			reread: leaf,
+		},
+		{
+			// A retry writes leaf 2 again
+			name:   "written twice",
+			writes: [][2]int{{0, len(data)}, {2 * leaf, 2*leaf + 500}},
+			reread: leaf,
+		},
+		{
+			// Resumed from an earlier run, leaf 0 and the last one were already on disk
+			name:   "never written",
+			writes: [][2]int{{leaf, 5 * leaf}},
+			reread: leaf + 4321,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewTreeHasher()
+			for _, w := range tt.writes {
+				// In pieces of random size, as a worker copies them
+				for off := w[0]; off < w[1]; {
+					end := min(off+1+rand.Intn(100000), w[1])
+					h.WriteAt(data[off:end], int64(off))
+					off = end
+				}
+			}
+			r := &countingReaderAt{r: bytes.NewReader(data)}
+			got, err := h.Sum(r, uint64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got %x, want %x", got, want)
+			}
+			if r.n > tt.reread {
+				t.Errorf("read back %d bytes, want at most %d", r.n, tt.reread)
+			}
+		})
+	}
+}
+
+func TestDownloadTreeChecksum(t *testing.T) {
+	data := testData(3*TreeHashLeafSize + 999)
+	srv := newServer(t, serveData(data))
+	tests := []struct {
+		name string
+		sum  []byte
+		err  bool
+	}{
+		{name: "match", sum: sequentialTreeSum(data)},
+		{name: "mismatch", sum: sequentialTreeSum(data[1:]), err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewChecksum(TreeSHA256, hex.EncodeToString(tt.sum))
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := testOptions()
+			opts.Concurrency = 3
+			opts.Checksum = c
+			got, err := download(t, srv, opts)
+			var mismatch *ChecksumMismatchError
+			switch {
+			case tt.err:
+				if !errors.As(err, &mismatch) {
+					t.Fatalf("got error %v, want a checksum mismatch", err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case !bytes.Equal(got, data):
+				t.Error("download doesn't match the served file")
+			}
+		})
+	}
+}
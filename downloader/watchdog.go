@@ -0,0 +1,62 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultStallWindow is how long a request may stay under
+// Options.StallSpeed when StallWindow isn't set
+const DefaultStallWindow = 10 * time.Second
+
+// errStalled ends an attempt that was too slow for too long, it is retried
+// like a dropped connection
+var errStalled = errors.New("transfer stalled")
+
+// watchdog counts the bytes of one attempt so watch can tell when it slows
+// to a crawl
+type watchdog struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (d *watchdog) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.n.Add(int64(n))
+	return n, err
+}
+
+// watch returns a context for the attempt that is cancelled with errStalled
+// as soon as fewer than speed bytes per second went through d over a whole
+// window, and a func to stop watching
+func (d *watchdog) watch(ctx context.Context, speed uint64, window time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		last := d.n.Load()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			n := d.n.Load()
+			if float64(n-last) < float64(speed)*window.Seconds() {
+				cancel(errStalled)
+				return
+			}
+			last = n
+		}
+	}()
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
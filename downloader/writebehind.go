@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DefaultWriteBehind is a cap on the bytes held for a slow disk that most
+// machines can spare
+const DefaultWriteBehind = 64 << 20 // 64 MiB
+
+// writeBehind takes finished chunks off the workers and writes them to the
+// file from a single goroutine, lowest offset first, so a slow disk doesn't
+// hold up the network. At most limit bytes are held at once, workers wait
+// for room before fetching their next chunk. A chunk is only reported as
+// done once it is in the file, so the sidecar never claims bytes that are
+// still in memory.
+//
+// An ordered writeBehind only ever writes the chunk starting where the last
+// one ended, for a file that has to be written front to back. The chunk it
+// waits for may always be reserved so the limit can't lock it out.
+type writeBehind struct {
+	file    io.WriterAt
+	limit   uint64
+	ordered bool
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// Bytes reserved by workers or waiting to be written
+	held    uint64
+	pending []*pendingChunk
+	closed  bool
+	err     error
+	done    chan struct{}
+	// Where the next chunk starts when ordered
+	next uint64
+}
+
+type pendingChunk struct {
+	buf *chunkBuffer
+	n   int64
+	// Called once the chunk is in the file
+	written func()
+}
+
+// chunkBuffer collects one chunk in memory, as an io.WriterAt taking the
+// chunk's offsets in the file
+type chunkBuffer struct {
+	start  uint64
+	length uint64
+	data   *[]byte
+}
+
+func (b *chunkBuffer) WriteAt(p []byte, off int64) (int, error) {
+	i := off - int64(b.start)
+	if i < 0 || uint64(i)+uint64(len(p)) > b.length {
+		return 0, fmt.Errorf("write at %d is outside the chunk at %d", off, b.start)
+	}
+	return copy((*b.data)[i:], p), nil
+}
+
+func newWriteBehind(file io.WriterAt, limit uint64, ordered bool) *writeBehind {
+	wb := &writeBehind{file: file, limit: limit, ordered: ordered, done: make(chan struct{})}
+	wb.cond = sync.NewCond(&wb.mu)
+	go wb.run()
+	return wb
+}
+
+// reserve waits until there is room for length more bytes and returns a
+// buffer for them, taken from a pool of bufSize buffers. A chunk larger than
+// the limit only has to wait until nothing else is held.
+func (wb *writeBehind) reserve(ctx context.Context, start, length uint64, bufSize int) (*chunkBuffer, error) {
+	stop := context.AfterFunc(ctx, func() {
+		wb.mu.Lock()
+		wb.cond.Broadcast()
+		wb.mu.Unlock()
+	})
+	defer stop()
+
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	for wb.held > 0 && wb.held+length > wb.limit && !(wb.ordered && start == wb.next) && wb.err == nil && ctx.Err() == nil {
+		wb.cond.Wait()
+	}
+	if wb.err != nil {
+		return nil, wb.err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	wb.held += length
+	return &chunkBuffer{start: start, length: length, data: getBuffer(max(bufSize, int(length)))}, nil
+}
+
+// submit queues the first n bytes of buf for writing
+func (wb *writeBehind) submit(buf *chunkBuffer, n int64, written func()) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.pending = append(wb.pending, &pendingChunk{buf: buf, n: n, written: written})
+	wb.cond.Broadcast()
+}
+
+// release gives back the room of a chunk that failed
+func (wb *writeBehind) release(buf *chunkBuffer) {
+	putBuffer(buf.data)
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.held -= buf.length
+	wb.cond.Broadcast()
+}
+
+// fail stops an ordered writeBehind whose next chunk will never come, so
+// workers don't wait for room that is never freed
+func (wb *writeBehind) fail(err error) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if wb.err == nil {
+		wb.err = err
+	}
+	wb.cond.Broadcast()
+}
+
+// lowest returns the index of the pending chunk to write next, -1 if an
+// ordered writeBehind doesn't have it yet
+func (wb *writeBehind) lowest() int {
+	next := -1
+	for i, c := range wb.pending {
+		if next < 0 || c.buf.start < wb.pending[next].buf.start {
+			next = i
+		}
+	}
+	if wb.ordered && next >= 0 && wb.pending[next].buf.start != wb.next {
+		return -1
+	}
+	return next
+}
+
+func (wb *writeBehind) run() {
+	defer close(wb.done)
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	for {
+		next := wb.lowest()
+		stopped := wb.ordered && wb.err != nil
+		for next < 0 && !wb.closed && !stopped {
+			wb.cond.Wait()
+			next, stopped = wb.lowest(), wb.ordered && wb.err != nil
+		}
+		if next < 0 || stopped {
+			// What is left can't be written in order
+			for _, c := range wb.pending {
+				putBuffer(c.buf.data)
+			}
+			wb.pending = nil
+			return
+		}
+		c := wb.pending[next]
+		wb.pending = append(wb.pending[:next], wb.pending[next+1:]...)
+		wb.mu.Unlock()
+
+		_, err := wb.file.WriteAt((*c.buf.data)[:c.n], int64(c.buf.start))
+		if err == nil {
+			c.written()
+		}
+		putBuffer(c.buf.data)
+
+		wb.mu.Lock()
+		if err != nil && wb.err == nil {
+			wb.err = err
+		}
+		wb.next = c.buf.start + uint64(c.n)
+		wb.held -= c.buf.length
+		wb.cond.Broadcast()
+	}
+}
+
+// close waits for every submitted chunk to be written and returns the first
+// error writing one of them
+func (wb *writeBehind) close() error {
+	wb.mu.Lock()
+	wb.closed = true
+	wb.cond.Broadcast()
+	wb.mu.Unlock()
+	<-wb.done
+	return wb.err
+}
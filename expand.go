@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// expandEnv replaces every ${NAME} in s with the variable looked up. $$ is
+// a literal $ and any other $ is left as it is. An unset variable is an
+// error rather than an empty string, so a typo doesn't go out as a request.
+func expandEnv(s string, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("missing } in %q", s[i:])
+			}
+			name := s[i+2 : i+end]
+			value, ok := lookup(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %q is not set", name)
+			}
+			b.WriteString(value)
+			s = s[i+end+1:]
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+		}
+	}
+}
+
+// expandArgs runs expandEnv over urls and the values of header in place
+func expandArgs(urls []string, header http.Header, lookup func(string) (string, bool)) error {
+	var err error
+	for i, u := range urls {
+		if urls[i], err = expandEnv(u, lookup); err != nil {
+			return fmt.Errorf("-url: %w", err)
+		}
+	}
+	for key, values := range header {
+		for i, v := range values {
+			if values[i], err = expandEnv(v, lookup); err != nil {
+				return fmt.Errorf("-header %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
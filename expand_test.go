@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	env := map[string]string{"HOST": "example.com", "TOKEN": "s3cr$t", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{in: "https://${HOST}/file", want: "https://example.com/file"},
+		{in: "Bearer ${TOKEN}", want: "Bearer s3cr$t"},
+		{in: "a${EMPTY}b", want: "ab"},
+		{in: "price$$5", want: "price$5"},
+		{in: "$HOST stays", want: "$HOST stays"},
+		{in: "ends with $", want: "ends with $"},
+		{in: "${HOST}${HOST}", want: "example.comexample.com"},
+		{in: "${MISSING}", err: true},
+		{in: "${HOST", err: true},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.in, lookup)
+		if (err != nil) != tt.err {
+			t.Errorf("expandEnv(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestExpandArgs(t *testing.T) {
+	lookup := func(name string) (string, bool) { return "x", name == "X" }
+	urls := []string{"http://${X}/a", "http://mirror/${X}"}
+	header := http.Header{"Authorization": {"Bearer ${X}"}}
+	if err := expandArgs(urls, header, lookup); err != nil {
+		t.Fatal(err)
+	}
+	if urls[0] != "http://x/a" || urls[1] != "http://mirror/x" {
+		t.Errorf("got urls %q", urls)
+	}
+	if got := header.Get("Authorization"); got != "Bearer x" {
+		t.Errorf("got header %q", got)
+	}
+	if err := expandArgs(nil, http.Header{"A": {"${Y}"}}, lookup); err == nil {
+		t.Error("unset variable in a header wasn't reported")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// listFlag collects every occurrence of a repeatable flag, also splitting
+// each on commas so "-url a -url b" and "-url a,b" are the same. data: URLs
+// are kept whole.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	// A data: URL has a comma of its own
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(value)), "data:") {
+		*l = append(*l, strings.TrimSpace(value))
+		return nil
+	}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// headerFlag collects repeated "Key: Value" flags. Values are kept whole,
+// commas included.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var pairs []string
+	for key, values := range h {
+		for _, v := range values {
+			pairs = append(pairs, key+": "+v)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlag) Set(value string) error {
+	key, v, ok := strings.Cut(value, ":")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, " \t") {
+		return fmt.Errorf("header %q must be given as \"Key: Value\"", value)
+	}
+	http.Header(h).Add(key, strings.TrimSpace(v))
+	return nil
+}
+
+// clobberFlag is -no-clobber, which on its own skips existing files and
+// with =error fails on them
+type clobberFlag string
+
+const (
+	clobberDefault = ""
+	clobberSkip    = "skip"
+	clobberError   = "error"
+)
+
+// IsBoolFlag lets the flag be given without a value
+func (c *clobberFlag) IsBoolFlag() bool { return true }
+
+func (c *clobberFlag) String() string {
+	if c == nil || *c == clobberDefault {
+		return "false"
+	}
+	return string(*c)
+}
+
+func (c *clobberFlag) Set(value string) error {
+	switch value {
+	case "true", clobberSkip:
+		*c = clobberSkip
+	case clobberError:
+		*c = clobberError
+	case "false":
+		*c = clobberDefault
+	default:
+		return fmt.Errorf("unknown -no-clobber mode %q, want skip or error", value)
+	}
+	return nil
+}
+
+// cookieFlag collects repeated "name=value" flags
+type cookieFlag []*http.Cookie
+
+func (c *cookieFlag) String() string {
+	var pairs []string
+	for _, cookie := range *c {
+		pairs = append(pairs, cookie.Name+"="+cookie.Value)
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func (c *cookieFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("cookie %q must be given as name=value", value)
+	}
+	*c = append(*c, &http.Cookie{Name: name, Value: strings.TrimSpace(v)})
+	return nil
+}
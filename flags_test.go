@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+func TestClobberFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  clobberFlag
+		err   bool
+	}{
+		{value: "true", want: clobberSkip},
+		{value: "skip", want: clobberSkip},
+		{value: "error", want: clobberError},
+		{value: "false", want: clobberDefault},
+		{value: "clobber", err: true},
+	}
+	for _, tt := range tests {
+		var c clobberFlag
+		err := c.Set(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("Set(%q) error = %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if c != tt.want {
+			t.Errorf("Set(%q) = %q, want %q", tt.value, c, tt.want)
+		}
+	}
+}
+
+func TestListFlag(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   listFlag
+	}{
+		{values: []string{"http://a/file", "http://b/file"}, want: listFlag{"http://a/file", "http://b/file"}},
+		{values: []string{"http://a/file, http://b/file,"}, want: listFlag{"http://a/file", "http://b/file"}},
+		{values: []string{"data:text/plain,a,b", "http://a/file"}, want: listFlag{"data:text/plain,a,b", "http://a/file"}},
+	}
+	for _, tt := range tests {
+		var l listFlag
+		for _, v := range tt.values {
+			l.Set(v)
+		}
+		if !reflect.DeepEqual(l, tt.want) {
+			t.Errorf("Set(%q) = %q, want %q", tt.values, l, tt.want)
+		}
+	}
+}
+
+func TestClobberErr(t *testing.T) {
+	exists := fmt.Errorf("dest: %w", downloader.ErrExists)
+	other := errors.New("other")
+	tests := []struct {
+		err  error
+		mode clobberFlag
+		want error
+	}{
+		{err: exists, mode: clobberSkip, want: exists},
+		{err: exists, mode: clobberError, want: errClobber},
+		{err: other, mode: clobberError, want: other},
+		{err: nil, mode: clobberError, want: nil},
+	}
+	for _, tt := range tests {
+		if got := clobberErr(tt.err, tt.mode); got != tt.want {
+			t.Errorf("clobberErr(%v, %q) = %v, want %v", tt.err, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestCookieFlag(t *testing.T) {
+	var c cookieFlag
+	for _, v := range []string{"session=abc", " token = x=y ", "empty="} {
+		if err := c.Set(v); err != nil {
+			t.Fatalf("Set(%q): %v", v, err)
+		}
+	}
+	if got := c.String(); got != "session=abc; token=x=y; empty=" {
+		t.Errorf("got %q", got)
+	}
+	for _, v := range []string{"novalue", "=x"} {
+		if err := c.Set(v); err == nil {
+			t.Errorf("Set(%q) succeeded", v)
+		}
+	}
+}
@@ -1,3 +1,14 @@
 module github.com/keshavchand/downloader
 
 go 1.21.4
+
+require (
+	github.com/jlaffaye/ftp v0.2.0
+	golang.org/x/sys v0.20.0
+	golang.org/x/time v0.5.0
+)
+
+require (
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
+)
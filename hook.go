@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// hookPlaceholder is replaced by the path of the downloaded file in the
+// -on-complete command
+const hookPlaceholder = "{}"
+
+// completionHook returns the downloader.Options.OnComplete running command
+// through sh with every {} replaced by the shell-quoted path
+func completionHook(command string) func(path string) error {
+	return func(path string) error {
+		cmd := exec.Command("sh", "-c", strings.ReplaceAll(command, hookPlaceholder, shellQuote(path)))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("-on-complete: %w", err)
+		}
+		return nil
+	}
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -5,153 +5,978 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
-	"sync"
-	"sync/atomic"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
 )
 
-func init() {
-	log.SetFlags(log.Lshortfile)
-}
+// exitInterrupted is what shells report for a process killed by SIGINT
+const exitInterrupted = 130
+
+// errClobber replaces downloader.ErrExists under -no-clobber=error
+var errClobber = errors.New("file exists and -no-clobber=error is set")
 
-type downloader struct {
-	client *http.Client
+// clobberErr turns a skipped download into a failure when mode asks for it
+func clobberErr(err error, mode clobberFlag) error {
+	if mode == clobberError && errors.Is(err, downloader.ErrExists) {
+		return errClobber
+	}
+	return err
 }
 
-type Status struct {
-	Downloaded int
+func init() {
+	log.SetFlags(0)
 }
 
-func (d *downloader) Download(request *http.Request, location io.Writer) error {
-	client := d.client
-	resp, err := client.Do(request)
-	if err != nil {
-		log.Println("Error while downloading", request.URL, "-", err)
-		return err
+func printPlan(plan *downloader.Plan) {
+	fmt.Println("URL:     ", strings.Join(plan.URLs, ", "))
+	fmt.Println("Name:    ", plan.Dest)
+	if plan.Remote.UnknownSize {
+		fmt.Println("Size:     unknown")
+	} else {
+		fmt.Printf("Size:     %d bytes (%s)\n", plan.Remote.Size, formatBytes(int64(plan.Remote.Size)))
 	}
-	defer resp.Body.Close()
-	_, err = io.Copy(location, resp.Body)
-	return err
+	if plan.Range != nil {
+		fmt.Printf("Range:    bytes %s (%s)\n", plan.Range, formatBytes(int64(plan.Size)))
+	}
+	if plan.Ranged {
+		accepting := 0
+		for _, ok := range plan.AcceptRanges {
+			if ok {
+				accepting++
+			}
+		}
+		if accepting < len(plan.URLs) {
+			fmt.Printf("Ranges:   supported by %d of %d mirrors\n", accepting, len(plan.URLs))
+		} else {
+			fmt.Println("Ranges:   supported")
+		}
+		fmt.Printf("Chunks:   %d x %s\n", plan.Chunks, formatBytes(int64(plan.ChunkSize)))
+		if plan.Align > 0 {
+			fmt.Printf("Aligned:  to %s\n", formatBytes(int64(plan.Align)))
+		}
+	} else {
+		fmt.Println("Ranges:   not supported, single stream")
+	}
+	fmt.Println("Workers: ", plan.Workers)
 }
 
-func GetFileSize(url string) (uint64, error) {
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodHead, url, nil)
-	if err != nil {
-		return 0, err
+// printRemote shows what the server said about the file, as used to plan a
+// download
+func printRemote(remote *downloader.RemoteFile) {
+	fmt.Println("URL:           ", remote.URL)
+	if remote.Status != "" {
+		fmt.Println("Status:        ", remote.Status)
 	}
-
-	client := http.Client{}
-	resp, err := client.Do(req)
-	contentlength := resp.Header.Get("Content-Length")
-	if contentlength == "" {
-		return 0, errors.New("Content-Length not found")
+	if remote.NotModified {
+		return
+	}
+	if remote.UnknownSize {
+		fmt.Println("Content-Length: unknown")
+	} else {
+		fmt.Printf("Content-Length: %d (%s)\n", remote.Size, formatBytes(int64(remote.Size)))
+	}
+	if remote.ContentType != "" {
+		fmt.Println("Content-Type:  ", remote.ContentType)
+	}
+	if remote.AcceptRanges {
+		fmt.Println("Accept-Ranges:  bytes")
+	} else {
+		fmt.Println("Accept-Ranges:  none")
+	}
+	if remote.ETag != "" {
+		fmt.Println("ETag:          ", remote.ETag)
+	}
+	if remote.LastModified != "" {
+		fmt.Println("Last-Modified: ", remote.LastModified)
+	}
+	if remote.Filename != "" {
+		fmt.Println("Filename:      ", remote.Filename)
 	}
-	length, err := strconv.ParseUint(contentlength, 10, 64)
-	return length, err
 }
 
-func Exists(name string, override bool) bool {
-	_, err := os.Stat(name)
+// writeHAR saves what har recorded to path, if anything was recording
+func writeHAR(har *downloader.HARRecorder, path string) {
+	if har == nil {
+		return
+	}
+	file, err := os.Create(path)
 	if err == nil {
-		if override {
-			return true
+		_, err = har.WriteTo(file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
 		}
-		log.Println("File exists make sure the *override* flag is set to continue")
-		return false
-	} else if os.IsNotExist(err) {
-		return true
-	} else {
-		log.Println("Error while checking if file exists", err)
-		return false
+	}
+	if err != nil {
+		log.Println("Error writing", path, "-", err)
 	}
 }
 
 func main() {
-	var url, name string
-	var override bool
-	var concurrencyLevel int
+	var urls listFlag
+	var resumeFrom string
+	var maxConc int
+	var name string
+	var opts downloader.Options
+	var checksum, sha256sum, sha1sum, md5sum string
+	var verifyRemote, requireRemote bool
+	var checksumOnly bool
+	var rateLimit string
+	var chunkSize string
+	var mode string
+	var align string
+	var bufferSize, writeBuffer string
+	var writeBehind bool
+	var writeBehindSize string
+	var maxSize string
+	var stallSpeed string
+	var maxRedirects int
+	var byteRange string
+	var byteRanges string
+	var user, bearer string
+	var deadline time.Duration
+	var dryRun bool
+	var headOnly bool
+	var jsonProgress bool
+	var quiet, verbose bool
+	var summaryFormat string
+	var overwrite string
+	var noClobber clobberFlag
+	var configPath string
+	var smoothing float64
+	var manifest string
+	var split, join string
+	var compress string
+	var blockManifest string
+	var expand bool
+	var tui bool
+	var jobs int
+	var perHost int
+	var proxy string
+	var unixSocket string
+	var ipVersion string
+	var http2 string
+	var onComplete string
+	var caCert, cert, key string
+	var insecure bool
+	var checkSpace, noCheckSpace bool
+	var verifySize, noVerifySize bool
+	var profile bool
+	var profileOut string
+	var metricsAddr string
+	var harPath, harBodies string
+	header := headerFlag{}
+	var cookies cookieFlag
+	var cookieFile string
+
+	flag.Var(&urls, "url", "http(s), ftp(s), file or data URL to download, repeat or separate with commas to add mirrors")
+	flag.StringVar(&manifest, "manifest", "", "file listing one \"url [name]\" per line to download instead of -url")
+	flag.IntVar(&jobs, "jobs", 4, "number of -manifest files downloaded at once, sharing the -conc connections")
+	flag.BoolVar(&tui, "tui", false, "show a progress bar for every -manifest file in flight and a total (only on a terminal)")
+	flag.StringVar(&name, "name", "", "name of target file (taken from the server or the URL if empty, - for stdout)")
+	flag.StringVar(&opts.OutputDir, "output-dir", "", "directory the file is saved in, created if missing")
+	flag.BoolVar(&opts.GuessExtension, "guess-extension", false, "add an extension from the Content-Type to a name taken from the server or URL that has none")
+	flag.BoolVar(&opts.IfNewer, "if-newer", false, "only download if the remote file is newer than the local one, and replace it then")
+	flag.BoolVar(&opts.Override, "override", false, "override file")
+	flag.StringVar(&overwrite, "overwrite", "", "when to replace an existing file: always, if-larger, if-different-size or never (instead of -override)")
+	flag.Var(&noClobber, "no-clobber", "leave an existing file alone without contacting the server and exit 0, or fail with -no-clobber=error")
+	flag.BoolVar(&checkSpace, "check-space", true, "make sure the file fits on disk before downloading")
+	flag.BoolVar(&noCheckSpace, "no-check-space", false, "skip the free space check")
+	flag.BoolVar(&verifySize, "verify-size", true, "make sure the finished file is as long as the server said")
+	flag.BoolVar(&noVerifySize, "no-verify-size", false, "skip the final size check")
+	flag.BoolVar(&opts.Continue, "continue", false, "take an existing file as the start of the download and only fetch the rest")
+	flag.StringVar(&resumeFrom, "resume-from-url", "", "resume the partial download of -name from this URL instead of -url, e.g. once a signed URL expired; fails unless size and ETag or Last-Modified still match")
+	flag.StringVar(&split, "split", "", "save the file as -name.part000, .part001 and so on of this size each, e.g. 100M, instead of one file")
+	flag.StringVar(&compress, "compress", compressNone, "save the file gzip compressed as -name.gz (gzip), fetched in a single stream, or as it is (none)")
+	flag.StringVar(&join, "join", "", "concatenate the parts of this file written by -split into it and exit")
+	flag.BoolVar(&dryRun, "dry-run", false, "print what would be downloaded and exit")
+	flag.BoolVar(&headOnly, "head-only", false, "print what the server says about each -url and exit")
+	flag.BoolVar(&quiet, "quiet", false, "only print errors")
+	flag.Float64Var(&smoothing, "speed-smoothing", defaultSmoothing, "weight between 0 and 1 of the latest sample in the shown speed, lower is smoother")
+	flag.StringVar(&summaryFormat, "summary-format", "", "how to print the summary at the end: text, json or none (defaults to json with -json, none with -quiet)")
+	flag.BoolVar(&verbose, "verbose", false, "also log every chunk")
+	flag.BoolVar(&profile, "profile", false, "print how long every chunk took at the end")
+	flag.StringVar(&profileOut, "profile-out", "", "write the timing of every chunk as CSV to this file")
+	flag.StringVar(&harPath, "har", "", "record every HTTP request and response to this HAR file, for reporting problems with a server")
+	flag.StringVar(&harBodies, "har-bodies", "0", "keep up to this much of each response body in the -har file, e.g. 4K")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address under /metrics, e.g. :9100")
+	flag.BoolVar(&jsonProgress, "json", false, "report progress as newline-delimited JSON on stderr instead of the progress bar")
+	flag.IntVar(&opts.Concurrency, "conc", downloader.DefaultConcurrency, "concurrency level (number of threads)")
+	flag.BoolVar(&opts.Adaptive, "adaptive", false, "start with a couple of threads and add or retire them depending on whether that makes the download faster")
+	flag.IntVar(&maxConc, "max-conc", 0, "most threads -adaptive goes up to (default -conc)")
+	flag.IntVar(&perHost, "per-host", 0, "maximum requests in flight to one host across all downloads (0 means no limit)")
+	flag.IntVar(&opts.MaxConnsPerHost, "max-conns", 0, "maximum TCP connections to one host (0 means no limit)")
+	flag.StringVar(&chunkSize, "chunk", "auto", "size of each ranged request, e.g. 4M, or auto to split the file between the threads")
+	flag.IntVar(&opts.SequentialFirst, "sequential-first", 0, "fetch the first this many chunks one after another before the other threads start, so the file can be read from the start early")
+	flag.StringVar(&mode, "mode", "chunks", "how the file is split: chunks handed to the threads as they free up, or segments, one per thread fetched in a single request")
+	flag.StringVar(&align, "align", "", "start every chunk on a multiple of this power of two, e.g. 4K or 1M")
+	flag.StringVar(&bufferSize, "buffer", "32K", "size of the buffer each thread copies through")
+	flag.BoolVar(&writeBehind, "write-behind", false, "hold finished chunks in memory and write them from a single thread, for slow disks")
+	flag.StringVar(&writeBehindSize, "write-behind-size", "64M", "most bytes -write-behind holds in memory")
+	flag.StringVar(&writeBuffer, "write-buffer", "0", "gather this many bytes per thread before writing them to disk, e.g. 1M (0 writes straight away)")
+	flag.IntVar(&opts.Retries, "retries", downloader.DefaultRetries, "number of times a failed chunk is retried")
+	flag.DurationVar(&opts.RetryBaseDelay, "retry-base-delay", downloader.DefaultRetryBaseDelay, "delay before the first retry, doubled on every further retry")
+	flag.DurationVar(&opts.MaxRetryDelay, "max-retry-delay", downloader.DefaultMaxRetryDelay, "longest wait before a retry, even if the server asks for more in Retry-After")
 
-	var chunkSize uint64 = 10 * 1024 * 1024 // 1 MB
+	flag.StringVar(&proxy, "proxy", "", "proxy for all requests as http://, https:// or socks5://host:port (defaults to HTTP_PROXY/HTTPS_PROXY)")
+	flag.StringVar(&unixSocket, "unix-socket", "", "connect to this unix socket instead of the host in the URL")
+	flag.StringVar(&http2, "http2", "on", "use HTTP/2 for https URLs when the server offers it (on) or always HTTP/1.1 (off)")
+	flag.StringVar(&ipVersion, "ip-version", "auto", "connect over IPv4 (4), IPv6 (6) or whichever connects first (auto)")
+	flag.DurationVar(&opts.Timeout, "timeout", 0, "timeout for each request, e.g. 30s (0 means none)")
+	flag.IntVar(&maxRedirects, "max-redirects", downloader.DefaultMaxRedirects, "most redirects followed per request, 0 makes a redirect an error")
+	flag.StringVar(&stallSpeed, "stall-speed", "", "retry a chunk whose request stays slower than this per second for -stall-window, e.g. 10K")
+	flag.DurationVar(&opts.StallWindow, "stall-window", downloader.DefaultStallWindow, "how long a request may stay under -stall-speed")
+	flag.DurationVar(&opts.RequestDelay, "request-delay", 0, "pause about this long (plus or minus half) between the chunk requests of each thread, e.g. 500ms")
+	flag.DurationVar(&deadline, "deadline", 0, "maximum time for the whole download (0 means none)")
 
-	flag.StringVar(&url, "url", "", "URL to download")
-	flag.StringVar(&name, "name", "", "name of target file")
-	flag.BoolVar(&override, "override", false, "override file")
-	flag.IntVar(&concurrencyLevel, "conc", 10, "concurrency level (number of threads)")
+	flag.Var(header, "header", "extra request header as \"Key: Value\", can be repeated")
+	flag.StringVar(&caCert, "cacert", "", "PEM file with extra CA certificates to trust")
+	flag.StringVar(&cert, "cert", "", "PEM client certificate for mutual TLS, needs -key")
+	flag.StringVar(&key, "key", "", "PEM private key for -cert")
+	flag.BoolVar(&insecure, "insecure", false, "don't verify TLS certificates")
+	flag.StringVar(&opts.UserAgent, "user-agent", downloader.DefaultUserAgent, "User-Agent sent with every request")
+	flag.StringVar(&user, "user", "", "credentials for Basic auth as user:pass (or set DL_USER)")
+	flag.StringVar(&bearer, "bearer", "", "token for Bearer auth (or set DL_TOKEN)")
+	flag.StringVar(&byteRange, "range", "", "only download bytes start-end of the file (both inclusive), e.g. 0-1023 or 1M-2M")
+	flag.StringVar(&byteRanges, "ranges", "", "only download these comma separated spans of the file, each at its own offset with holes in between, e.g. 0-99,5000-5999")
+	flag.StringVar(&maxSize, "max-size", "", "refuse files bigger than this, e.g. 2G")
+	flag.StringVar(&rateLimit, "rate", "", "maximum download speed per second across all threads and -manifest files, which share it evenly, e.g. 500K or 5MB")
+	flag.StringVar(&checksum, "checksum", "", "expected checksum as algo:hex (sha256, sha1, md5 or tree-sha256, which is checked as chunks arrive)")
+	flag.StringVar(&sha256sum, "sha256", "", "expected SHA-256 of the file (hex)")
+	flag.StringVar(&sha1sum, "sha1", "", "expected SHA-1 of the file (hex)")
+	flag.StringVar(&md5sum, "md5", "", "expected MD5 of the file (hex)")
+	flag.StringVar(&onComplete, "on-complete", "", "shell command to run after a successful download, {} is replaced by the quoted path of the file")
+	flag.BoolVar(&verifyRemote, "verify-remote", false, "verify against the checksum published next to the file as .sha256, .sha1 or .md5")
+	flag.BoolVar(&checksumOnly, "checksum-only", false, "don't download, only verify the existing -name against the given or published checksum, exiting 1 on a mismatch")
+	flag.BoolVar(&requireRemote, "require-remote-checksum", false, "with -verify-remote, fail if no published checksum is found instead of warning")
+	flag.StringVar(&blockManifest, "block-manifest", "", "file of \"offset,length,sha256\" lines to check every block as soon as it arrives, retrying the ones that don't match")
+	flag.BoolVar(&opts.KeepOnMismatch, "keep-on-mismatch", false, "keep the file if its checksum doesn't match")
+
+	flag.BoolVar(&expand, "expand-env", false, "replace ${VAR} in -url, -header and -manifest URLs with the environment variable, $$ for a literal $")
+	flag.Var(&cookies, "cookie", "cookie sent to the -url hosts as name=value, can be repeated")
+	flag.StringVar(&cookieFile, "cookie-file", "", "Netscape format cookie file, as written by curl -c or browser extensions")
+	flag.StringVar(&configPath, "config", "", "JSON file with defaults for any of these flags, keyed by flag name (default ~/"+defaultConfig+" if it exists)")
 
 	flag.Parse()
+	if err := loadConfig(flag.CommandLine, configPath); err != nil {
+		log.Fatal(err)
+	}
 
-	if !Exists(name, override) {
+	if join != "" {
+		if len(urls) > 0 || manifest != "" {
+			log.Fatal("-join can't be combined with -url or -manifest")
+		}
+		parts, err := downloader.JoinParts(join)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Joined", parts, "parts into", join)
 		return
 	}
 
-	size, err := GetFileSize(url)
-	if err != nil {
-		log.Fatal(err)
+	if resumeFrom != "" {
+		switch {
+		case len(urls) > 0 || manifest != "":
+			log.Fatal("-resume-from-url replaces -url and can't be used with -manifest")
+		case name == "-" || split != "" || compress != compressNone:
+			log.Fatal("-resume-from-url can't be used with stdout, -split or -compress")
+		}
+		urls = listFlag{resumeFrom}
+		opts.MustResume = true
 	}
 
-	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0664)
-	if err != nil {
+	if expand {
+		if err := expandArgs(urls, http.Header(header), os.LookupEnv); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	switch {
+	case manifest != "" && (len(urls) > 0 || name != ""):
+		log.Fatal("-manifest can't be combined with -url or -name")
+	case manifest == "" && len(urls) == 0 && !(checksumOnly && name != "" && !verifyRemote):
+		log.Fatal("-url is required")
+	case len(urls) > 0:
+		opts.Mirrors = urls[1:]
+	}
+	if tui && manifest == "" {
+		log.Fatal("-tui needs -manifest")
+	}
+	// Bars would only garble a pipe or the JSON lines
+	tui = tui && isTerminal(os.Stdout) && !jsonProgress && !quiet
+	switch {
+	case opts.Concurrency <= 0:
+		log.Fatal("-conc must be positive")
+	case opts.Concurrency > downloader.MaxConcurrency:
+		log.Println("Limiting -conc to", downloader.MaxConcurrency)
+		opts.Concurrency = downloader.MaxConcurrency
+	}
+	if maxConc != 0 {
+		switch {
+		case !opts.Adaptive:
+			log.Fatal("-max-conc needs -adaptive")
+		case maxConc < 0:
+			log.Fatal("-max-conc must be positive")
+		case maxConc > downloader.MaxConcurrency:
+			log.Println("Limiting -max-conc to", downloader.MaxConcurrency)
+			maxConc = downloader.MaxConcurrency
+		}
+		opts.Concurrency = maxConc
+	}
+	switch {
+	case jobs <= 0:
+		log.Fatal("-jobs must be positive")
+	case opts.Retries < 0:
+		log.Fatal("-retries can't be negative")
+	case opts.MaxConnsPerHost < 0:
+		log.Fatal("-max-conns can't be negative")
+	case perHost < 0:
+		log.Fatal("-per-host can't be negative")
+	}
+	opts.CheckSpace = checkSpace && !noCheckSpace
+	opts.VerifySize = verifySize && !noVerifySize
+	if perHost > 0 {
+		opts.HostLimiter = downloader.NewHostLimiter(perHost)
+	}
+	if name == "-" && opts.OutputDir != "" {
+		log.Fatal("-output-dir can't be used when writing to stdout")
+	}
+	if onComplete != "" {
+		if name == "-" {
+			log.Fatal("-on-complete can't be used when writing to stdout")
+		}
+		opts.OnComplete = completionHook(onComplete)
+	}
+
+	level := downloader.LevelInfo
+	switch {
+	case quiet && verbose:
+		log.Fatal("only one of -quiet and -verbose can be set")
+	case smoothing <= 0 || smoothing > 1:
+		log.Fatal("-speed-smoothing must be above 0 and at most 1")
+	case summaryFormat == "" && quiet:
+		summaryFormat = summaryNone
+	case summaryFormat == "" && jsonProgress:
+		summaryFormat = summaryJSON
+	case summaryFormat == "":
+		summaryFormat = summaryText
+	case summaryFormat != summaryText && summaryFormat != summaryJSON && summaryFormat != summaryNone:
+		log.Fatal("-summary-format must be text, json or none")
+	}
+	switch {
+	case quiet:
+		level = downloader.LevelError
+	case verbose:
+		level = downloader.LevelDebug
+		log.SetFlags(log.Lshortfile)
+	}
+	opts.Logger = downloader.NewLogger(log.Default(), level)
+
+	sums := map[string]string{"sha256": sha256sum, "sha1": sha1sum, "md5": md5sum}
+	for algo, digest := range sums {
+		if digest == "" {
+			continue
+		}
+		if checksum != "" {
+			log.Fatal("only one of -checksum, -sha256, -sha1 and -md5 can be set")
+		}
+		checksum = algo + ":" + digest
+	}
+	if user == "" {
+		user = os.Getenv("DL_USER")
+	}
+	if bearer == "" {
+		bearer = os.Getenv("DL_TOKEN")
+	}
+	if user != "" {
+		username, password, ok := strings.Cut(user, ":")
+		if !ok {
+			log.Fatal("-user must be given as user:pass")
+		}
+		opts.Auth.Username = username
+		opts.Auth.Password = password
+	}
+	opts.Auth.Bearer = bearer
+	opts.Header = http.Header(header)
+	if len(cookies) > 0 || cookieFile != "" {
+		jar, err := newCookieJar(cookieFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := addCookies(jar, cookies, urls); err != nil {
+			log.Fatal(err)
+		}
+		opts.Jar = jar
+	}
+
+	if proxy != "" {
+		u, err := downloader.ParseProxy(proxy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Proxy = u
+	}
+
+	if unixSocket != "" {
+		opts.DialContext = downloader.UnixSocketDialer(unixSocket)
+	}
+	switch http2 {
+	case "on":
+	case "off":
+		opts.DisableHTTP2 = true
+	default:
+		log.Fatal("-http2 must be on or off")
+	}
+	switch ipVersion {
+	case "auto":
+	case "4", "6":
+		if unixSocket != "" {
+			log.Fatal("-ip-version can't be combined with -unix-socket")
+		}
+		dial, err := downloader.IPVersionDialer(int(ipVersion[0] - '0'))
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.DialContext = dial
+	default:
+		log.Fatal("-ip-version must be 4, 6 or auto")
+	}
+
+	if caCert != "" || cert != "" || key != "" || insecure {
+		config, err := downloader.LoadTLSConfig(caCert, cert, key, insecure)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if insecure {
+			log.Println("WARNING: -insecure is set, TLS certificates are not verified")
+		}
+		opts.TLSConfig = config
+	}
+
+	if chunkSize != "auto" {
+		size, err := downloader.ParseSize(chunkSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if size == 0 {
+			log.Fatal("-chunk must be positive")
+		}
+		opts.ChunkSize = size
+	}
+	switch mode {
+	case "chunks":
+	case "segments":
+		switch {
+		case chunkSize != "auto" || split != "":
+			log.Fatal("-mode segments can't be combined with -chunk or -split")
+		case opts.Adaptive || blockManifest != "":
+			log.Fatal("-mode segments can't be combined with -adaptive or -block-manifest")
+		}
+		opts.Segments = true
+	default:
+		log.Fatal("-mode must be chunks or segments")
+	}
+	if align != "" {
+		size, err := downloader.ParseSize(align)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if size == 0 || size&(size-1) != 0 {
+			log.Fatal("-align must be a power of two")
+		}
+		opts.Align = size
+	}
+
+	if size, err := downloader.ParseSize(bufferSize); err != nil {
 		log.Fatal(err)
+	} else if size == 0 {
+		log.Fatal("-buffer must be positive")
+	} else {
+		opts.BufferSize = int(size)
+	}
+	if size, err := downloader.ParseSize(writeBuffer); err != nil {
+		log.Fatal(err)
+	} else {
+		opts.WriteBufferSize = int(size)
+	}
+	if writeBehind {
+		size, err := downloader.ParseSize(writeBehindSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if size == 0 {
+			log.Fatal("-write-behind-size must be positive")
+		}
+		opts.WriteBehind = size
 	}
-	defer file.Close()
 
-	downloaders := make([]*downloader, concurrencyLevel)
-	for idx := range downloaders {
-		downloaders[idx] = &downloader{
-			client: &http.Client{},
+	if byteRange != "" {
+		if manifest != "" {
+			log.Fatal("-range can't be used with -manifest")
 		}
+		r, err := downloader.ParseByteRange(byteRange)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Range = &r
+	}
+	if byteRanges != "" {
+		switch {
+		case manifest != "":
+			log.Fatal("-ranges can't be used with -manifest")
+		case opts.Range != nil:
+			log.Fatal("-ranges can't be combined with -range")
+		case name == "-":
+			log.Fatal("-ranges leaves holes, it can't write to stdout")
+		}
+		ranges, err := downloader.ParseByteRanges(byteRanges)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Ranges = ranges
+	}
+
+	switch {
+	case maxRedirects < 0:
+		log.Fatal("-max-redirects can't be negative")
+	case maxRedirects == 0:
+		opts.MaxRedirects = -1
+	default:
+		opts.MaxRedirects = maxRedirects
 	}
 
-	status := make(chan Status, 1)
-	defer close(status)
+	if stallSpeed != "" {
+		size, err := downloader.ParseSize(stallSpeed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.StallSpeed = size
+	}
+	if opts.StallWindow <= 0 {
+		log.Fatal("-stall-window must be positive")
+	}
+	if opts.RequestDelay < 0 {
+		log.Fatal("-request-delay can't be negative")
+	}
+	if opts.SequentialFirst < 0 {
+		log.Fatal("-sequential-first can't be negative")
+	}
 
-	var partCount uint64
-	var wg sync.WaitGroup
-	defer wg.Wait()
+	if maxSize != "" {
+		size, err := downloader.ParseSize(maxSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if size == 0 {
+			log.Fatal("-max-size must be positive")
+		}
+		opts.MaxSize = size
+	}
 
-	go func() {
-		totalDownloaded := 0
-		for s := range status {
-			totalDownloaded += s.Downloaded
-			fmt.Printf("%.2f %% downloaded \r", float64(totalDownloaded)/float64(size))
+	if rateLimit != "" {
+		bytesPerSec, err := downloader.ParseSize(rateLimit)
+		if err != nil {
+			log.Fatal(err)
 		}
-		fmt.Println("Download complete")
-	}()
+		if bytesPerSec == 0 {
+			log.Fatal("-rate must be positive")
+		}
+		opts.RateLimiter = downloader.NewRateLimiter(bytesPerSec)
+	}
+	if overwrite != "" {
+		switch {
+		case opts.Override:
+			log.Fatal("only one of -override and -overwrite can be set")
+		case opts.IfNewer:
+			log.Fatal("-overwrite can't be combined with -if-newer")
+		}
+		policy, err := downloader.ParseOverwritePolicy(overwrite)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Overwrite = policy
+	}
+	if noClobber != clobberDefault {
+		switch {
+		case opts.Override || overwrite != "":
+			log.Fatal("-no-clobber can't be combined with -override or -overwrite")
+		case opts.IfNewer || opts.Continue:
+			log.Fatal("-no-clobber can't be combined with -if-newer or -continue")
+		}
+		opts.NoClobber = true
+	}
+	switch {
+	case requireRemote && !verifyRemote:
+		log.Fatal("-require-remote-checksum needs -verify-remote")
+	case verifyRemote && checksum != "":
+		log.Fatal("-verify-remote can't be combined with an explicit checksum")
+	case verifyRemote && manifest != "":
+		log.Fatal("checksums can't be used with -manifest")
+	}
+	if checksum != "" {
+		if manifest != "" {
+			log.Fatal("checksums can't be used with -manifest")
+		}
+		c, err := downloader.ParseChecksum(checksum)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Checksum = c
+	}
+	if checksumOnly {
+		switch {
+		case opts.Checksum == nil && !verifyRemote:
+			log.Fatal("-checksum-only needs a checksum or -verify-remote")
+		case manifest != "" || name == "-" || compress != compressNone:
+			log.Fatal("-checksum-only can't be used with -manifest, stdout or -compress")
+		case dryRun || headOnly:
+			log.Fatal("-checksum-only can't be combined with -dry-run or -head-only")
+		}
+	}
+	if blockManifest != "" {
+		switch {
+		case manifest != "":
+			log.Fatal("-block-manifest can't be used with -manifest")
+		case opts.Range != nil:
+			log.Fatal("-block-manifest can't be combined with -range")
+		}
+		blocks, err := readBlockManifest(blockManifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Blocks = blocks
+	}
+	var partSize uint64
+	if split != "" {
+		size, err := downloader.ParseSize(split)
+		if err != nil {
+			log.Fatal(err)
+		}
+		switch {
+		case size == 0:
+			log.Fatal("-split must be positive")
+		case manifest != "" || name == "" || name == "-":
+			log.Fatal("-split needs a -name for the parts and can't be used with -manifest")
+		case opts.Continue || opts.IfNewer || noClobber != clobberDefault || overwrite != "":
+			log.Fatal("-split can't be combined with -continue, -if-newer, -no-clobber or -overwrite")
+		case opts.Checksum != nil || verifyRemote:
+			log.Fatal("checksums can't be used with -split")
+		case onComplete != "":
+			log.Fatal("-on-complete can't be used with -split")
+		}
+		// One chunk per part
+		if opts.ChunkSize == 0 {
+			opts.ChunkSize = size
+		}
+		partSize = size
+	}
+	switch compress {
+	case compressNone:
+	case compressGzip:
+		switch {
+		case manifest != "" || name == "" || name == "-":
+			log.Fatal("-compress needs a -name and can't be used with -manifest")
+		case partSize > 0 || opts.Continue || len(opts.Ranges) > 0:
+			log.Fatal("-compress can't be combined with -split, -continue or -ranges")
+		case opts.IfNewer || noClobber != clobberDefault || overwrite != "":
+			log.Fatal("-compress can't be combined with -if-newer, -no-clobber or -overwrite")
+		case onComplete != "":
+			log.Fatal("-on-complete can't be used with -compress")
+		}
+	default:
+		log.Fatal("-compress must be gzip or none")
+	}
 
-	for i := 0; i < concurrencyLevel; i++ {
-		wg.Add(1)
-		go func(i int) {
-			defer wg.Done()
-			for {
-				// AddUint64 returns the new value
-				partCount := atomic.AddUint64(&partCount, 1) - 1
-				start := partCount * chunkSize
-				if start >= size {
-					return
-				}
-				// NOTE: Range is inclusive
-				end := (partCount+1)*chunkSize - 1
+	bodyLimit, parseErr := downloader.ParseSize(harBodies)
+	switch {
+	case parseErr != nil:
+		log.Fatal(parseErr)
+	case bodyLimit > 0 && harPath == "":
+		log.Fatal("-har-bodies needs -har")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
+	d := &downloader.Downloader{}
+
+	var har *downloader.HARRecorder
+	if harPath != "" {
+		har = downloader.NewHARRecorder(int(bodyLimit))
+		opts.HAR = har
+	}
 
-				request, err := http.NewRequest(http.MethodGet, url, nil)
+	var m *metrics
+	if metricsAddr != "" {
+		m = &metrics{}
+		serveMetrics(metricsAddr, m)
+	}
+
+	if manifest != "" {
+		entries, err := readManifest(manifest)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if expand {
+			for i, entry := range entries {
+				if entries[i].url, err = expandEnv(entry.url, os.LookupEnv); err != nil {
+					log.Fatal(manifest, ": ", err)
+				}
+			}
+		}
+		if len(cookies) > 0 {
+			for _, entry := range entries {
+				if err := addCookies(opts.Jar, cookies, []string{entry.url}); err != nil {
+					log.Fatal(manifest, ": ", err)
+				}
+			}
+		}
+		if dryRun {
+			for _, entry := range entries {
+				plan, err := d.Plan(ctx, entry.url, entry.name, opts)
 				if err != nil {
-					log.Println(err)
-					return
+					log.Fatal(err)
+				}
+				printPlan(plan)
+				fmt.Println()
+			}
+			return
+		}
+		if m != nil {
+			status := make(chan downloader.Status, 1)
+			opts.Status = status
+			go func() {
+				for s := range status {
+					m.observe(s)
 				}
+			}()
+		}
+		var view *batchView
+		if tui {
+			view = newBatchView(os.Stdout, os.Stderr, len(entries), smoothing)
+			view.next = opts.Status
+			log.SetOutput(view)
+			go view.run()
+		}
+		results := runBatch(ctx, d, entries, jobs, opts, view)
+		if view != nil {
+			view.stop()
+			log.SetOutput(os.Stderr)
+		}
+		writeHAR(har, harPath)
+		for i := range results {
+			results[i].err = clobberErr(results[i].err, noClobber)
+		}
+		failed := printSummary(os.Stderr, results)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			os.Exit(exitInterrupted)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
 
-				ranges := fmt.Sprintf("bytes=%d-%d", start, end)
-				request.Header.Set("Range", ranges)
-				offsetFile := io.NewOffsetWriter(file, int64(start))
-				err = downloaders[i].Download(request, offsetFile)
-				if err != nil {
-					log.Println("Error Downloading: ", err)
+	if headOnly {
+		failed := false
+		for i, u := range urls {
+			if i > 0 {
+				fmt.Println()
+			}
+			remote, err := downloader.Stat(ctx, u, opts)
+			var statusErr *downloader.HTTPStatusError
+			switch {
+			case errors.As(err, &statusErr):
+				fmt.Println("URL:           ", u)
+				fmt.Println("Status:        ", statusErr.Code, http.StatusText(statusErr.Code))
+				failed = true
+			case err != nil:
+				log.Println(err)
+				failed = true
+			default:
+				printRemote(remote)
+			}
+		}
+		writeHAR(har, harPath)
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if dryRun {
+		plan, err := d.Plan(ctx, urls[0], name, opts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printPlan(plan)
+		return
+	}
+
+	if verifyRemote {
+		c, err := d.RemoteChecksum(ctx, urls[0], opts)
+		switch {
+		case errors.Is(err, downloader.ErrNoRemoteChecksum) && !requireRemote:
+			log.Println("WARNING:", err, "- not verifying")
+		case err != nil:
+			log.Fatal(err)
+		default:
+			opts.Checksum = c
+		}
+	}
+
+	if checksumOnly {
+		path := name
+		if path == "" {
+			plan, err := d.Plan(ctx, urls[0], "", opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			path = plan.Dest
+		} else if opts.OutputDir != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(opts.OutputDir, path)
+		}
+		if opts.Checksum == nil {
+			log.Fatal("Nothing to verify ", path, " against")
+		}
+		err := downloader.VerifyFile(path, opts.Checksum)
+		var mismatch *downloader.ChecksumMismatchError
+		switch {
+		case errors.As(err, &mismatch):
+			log.Fatal(path, ": ", err)
+		case err != nil:
+			log.Fatal(err)
+		}
+		log.Println(path+":", opts.Checksum.Algo, "OK")
+		return
+	}
+
+	toStdout := name == "-"
+
+	// Always watched, an interruption reports how far the download got
+	status := make(chan downloader.Status, 1)
+	opts.Status = status
+	collectChunks := profile || profileOut != ""
+	// Only read by the progress goroutine once status is closed
+	var result error
+	// Closed once the progress goroutine printed everything, as the
+	// process may exit right after
+	done := make(chan struct{})
+
+	// Keep stdout clean when the file itself is written there
+	out := os.Stdout
+	if jsonProgress || toStdout {
+		out = os.Stderr
+	}
+	p := newProgress(out, jsonProgress, smoothing)
+	if p.term != nil {
+		// Messages from the workers mustn't land in the middle of the bar
+		log.SetOutput(p.term)
+	}
+
+	go func() {
+		defer close(done)
+		var chunks chunkProfile
+		ticker := time.NewTicker(p.interval())
+		defer ticker.Stop()
+		sampler := time.NewTicker(sampleInterval)
+		defer sampler.Stop()
+		for {
+			select {
+			case now := <-sampler.C:
+				p.sample(now)
+			case s, ok := <-status:
+				if !ok {
+					// -no-clobber stops before anything was fetched
+					refused := errors.Is(result, downloader.ErrExists) || errors.Is(result, errClobber)
+					if !quiet && !(noClobber != clobberDefault && refused) {
+						p.render(time.Now())
+						p.finish()
+					}
+					if summaryFormat != summaryNone {
+						p.summary(time.Now(), summaryFormat, result, opts.Checksum != nil)
+					}
+					if errors.Is(result, context.Canceled) {
+						log.Println(p.interrupted(!toStdout && partSize == 0 && compress == compressNone))
+					}
+					if !quiet && profile {
+						chunks.print(out)
+					}
+					if profileOut != "" {
+						if err := chunks.writeCSV(profileOut); err != nil {
+							log.Println("Error writing", profileOut, "-", err)
+						}
+					}
 					return
 				}
-				status <- Status{Downloaded: int(end - start + 1)}
+				p.add(s.Downloaded, s.Total, s.Time)
+				if s.Chunk != nil {
+					p.chunk(*s.Chunk)
+				}
+				if m != nil {
+					m.observe(s)
+				}
+				if s.Chunk != nil && s.Chunk.Err == nil && collectChunks {
+					chunks = append(chunks, *s.Chunk)
+				}
+			case now := <-ticker.C:
+				if !quiet {
+					p.render(now)
+				}
 			}
-		}(i)
+		}
+	}()
+
+	var err error
+	switch {
+	case toStdout:
+		err = d.DownloadTo(ctx, urls[0], os.Stdout, opts)
+	case partSize > 0:
+		err = downloadParts(ctx, d, urls[0], name, partSize, opts)
+	case compress == compressGzip:
+		err = downloadCompressed(ctx, d, urls[0], name, opts)
+	default:
+		err = clobberErr(d.Download(ctx, urls[0], name, opts), noClobber)
+	}
+	result = err
+	// Download only returns once its workers are gone, so nothing sends
+	// on status anymore
+	close(status)
+	<-done
+	writeHAR(har, harPath)
+
+	if errors.Is(err, downloader.ErrExists) || errors.Is(err, downloader.ErrUpToDate) {
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		os.Exit(exitInterrupted)
+	}
+	var incomplete *downloader.IncompleteError
+	if errors.As(err, &incomplete) {
+		for _, r := range incomplete.Failed {
+			log.Printf("Missing bytes %d-%d: %v", r.Start, r.End, r.Err)
+		}
+	}
+	// Pass on the hook's own exit code
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		log.Println(err)
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 }
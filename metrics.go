@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+// metrics sums up the Status updates of every download for Prometheus,
+// served in its text format so no client library is needed
+type metrics struct {
+	mu        sync.Mutex
+	bytes     int64
+	resumed   int64
+	completed int64
+	failed    int64
+	retries   int64
+	workers   int64
+	samples   []sample
+}
+
+func (m *metrics) observe(s downloader.Status) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// Bytes taken back after a failed attempt still went over the wire
+	if s.Resumed {
+		m.resumed += int64(s.Downloaded)
+	} else if s.Downloaded > 0 {
+		m.bytes += int64(s.Downloaded)
+	}
+	m.workers += int64(s.Workers)
+	if c := s.Chunk; c != nil {
+		if c.Err != nil {
+			m.failed++
+		} else {
+			m.completed++
+		}
+		if c.Attempts > 1 {
+			m.retries += int64(c.Attempts - 1)
+		}
+	}
+	m.samples = append(m.samples, sample{at: s.Time, downloaded: m.bytes})
+	cutoff := s.Time.Add(-speedWindow)
+	for len(m.samples) > 1 && m.samples[0].at.Before(cutoff) {
+		m.samples = m.samples[1:]
+	}
+}
+
+// speed is the throughput over the last speedWindow, zero once nothing has
+// arrived for that long
+func (m *metrics) speed(now time.Time) float64 {
+	if len(m.samples) == 0 {
+		return 0
+	}
+	first, last := m.samples[0], m.samples[len(m.samples)-1]
+	if now.Sub(last.at) > speedWindow {
+		return 0
+	}
+	elapsed := now.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(last.downloaded-first.downloaded) / elapsed
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	write := func(name, kind, help string, value any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+	write("downloader_bytes_total", "counter", "Bytes received.", m.bytes)
+	write("downloader_resumed_bytes_total", "counter", "Bytes already on disk when a download was resumed.", m.resumed)
+	write("downloader_chunks_completed_total", "counter", "Chunks written to disk.", m.completed)
+	write("downloader_chunks_failed_total", "counter", "Chunks given up on after all retries.", m.failed)
+	write("downloader_chunk_retries_total", "counter", "Retried chunk requests.", m.retries)
+	write("downloader_throughput_bytes_per_second", "gauge", "Download speed over the last 5 seconds.", m.speed(time.Now()))
+	write("downloader_active_workers", "gauge", "Workers currently fetching.", m.workers)
+}
+
+// serveMetrics exposes m on addr under /metrics until the process exits
+func serveMetrics(addr string, m *metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Fatal("-metrics-addr: ", err)
+		}
+	}()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+func TestMetricsResumedBytes(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file", time.Unix(1700000000, 0), bytes.NewReader(data))
+	}))
+	defer srv.Close()
+
+	// A quarter of the file left over from an earlier run
+	name := filepath.Join(t.TempDir(), "file")
+	resumed := len(data) / 4
+	if err := os.WriteFile(name, data[:resumed], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &metrics{}
+	status := make(chan downloader.Status)
+	done := make(chan struct{})
+	go func() {
+		for s := range status {
+			m.observe(s)
+		}
+		close(done)
+	}()
+	opts := downloader.Options{
+		Logger:   downloader.NewLogger(log.New(io.Discard, "", 0), downloader.LevelError),
+		Continue: true,
+		Status:   status,
+	}
+	err := (&downloader.Downloader{}).Download(context.Background(), srv.URL, name, opts)
+	close(status)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.bytes != int64(len(data)-resumed) || m.resumed != int64(resumed) {
+		t.Errorf("counted %d bytes received and %d resumed, want %d and %d", m.bytes, m.resumed, len(data)-resumed, resumed)
+	}
+}
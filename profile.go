@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+// chunkProfile collects the ChunkStats of every finished chunk for -profile
+type chunkProfile []downloader.ChunkStats
+
+func (c chunkProfile) sorted() chunkProfile {
+	sorted := append(chunkProfile(nil), c...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	return sorted
+}
+
+func chunkSpeed(s downloader.ChunkStats) float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.End-s.Start+1) / s.Duration.Seconds()
+}
+
+func (c chunkProfile) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "chunk\tstart\tend\tbytes\ttime\tspeed\tmirror\t")
+	for _, s := range c.sorted() {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\t%s/s\t%s\t\n", s.Index, s.Start, s.End,
+			formatBytes(int64(s.End-s.Start+1)), s.Duration.Round(time.Millisecond),
+			formatBytes(int64(chunkSpeed(s))), s.Mirror)
+	}
+	tw.Flush()
+}
+
+func (c chunkProfile) writeCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"chunk", "start", "end", "bytes", "began", "seconds", "bytes_per_second", "mirror"})
+	for _, s := range c.sorted() {
+		w.Write([]string{
+			strconv.Itoa(s.Index),
+			strconv.FormatUint(s.Start, 10),
+			strconv.FormatUint(s.End, 10),
+			strconv.FormatUint(s.End-s.Start+1, 10),
+			s.Began.Format(time.RFC3339Nano),
+			strconv.FormatFloat(s.Duration.Seconds(), 'f', 3, 64),
+			strconv.FormatFloat(chunkSpeed(s), 'f', 0, 64),
+			s.Mirror,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+const (
+	barWidth    = 30
+	speedWindow = 5 * time.Second
+
+	// How often the speed is sampled, however often it is shown
+	sampleInterval = 250 * time.Millisecond
+	// Weight of the newest sample in the moving average of the speed
+	defaultSmoothing = 0.3
+)
+
+// Values of -summary-format
+const (
+	summaryText = "text"
+	summaryJSON = "json"
+	summaryNone = "none"
+)
+
+type sample struct {
+	at         time.Time
+	downloaded int64
+}
+
+// progress renders the state aggregated from the status channel, either for
+// humans or as one JSON object per line
+type progress struct {
+	out  io.Writer
+	tty  bool
+	json bool
+	// Draws the line when tty is set
+	term *terminal
+
+	total      int64
+	downloaded int64
+
+	// Exponentially weighted moving average of the speed, updated by sample
+	smoothing float64
+	rate      float64
+	hasRate   bool
+	sampledAt time.Time
+	sampled   int64
+
+	// When the first Status arrived and how much was already on disk then,
+	// for the summary
+	start   time.Time
+	resumed int64
+	// Requests for chunks, the retries among them, how many chunks needed
+	// any and how long they waited in total
+	requests  int
+	retries   int
+	retried   int
+	retryWait time.Duration
+}
+
+func newProgress(out *os.File, jsonOutput bool, smoothing float64) *progress {
+	p := &progress{out: out, tty: isTerminal(out) && !jsonOutput, json: jsonOutput, smoothing: smoothing}
+	if p.tty {
+		p.term = &terminal{out: out, log: os.Stderr}
+	}
+	return p
+}
+
+type jsonProgress struct {
+	Downloaded int64     `json:"downloaded"`
+	Total      int64     `json:"total"`
+	Percent    float64   `json:"percent"`
+	Speed      float64   `json:"speed"`
+	Time       time.Time `json:"time"`
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// interval is how often the progress should be redrawn; piped output only
+// gets an occasional line so logs stay readable
+func (p *progress) interval() time.Duration {
+	switch {
+	case p.tty:
+		return 200 * time.Millisecond
+	case p.json:
+		return time.Second
+	default:
+		return 5 * time.Second
+	}
+}
+
+func (p *progress) add(downloaded, total int, at time.Time) {
+	if p.start.IsZero() {
+		p.start = at
+		p.resumed = int64(downloaded)
+	}
+	p.downloaded += int64(downloaded)
+	p.total = int64(total)
+}
+
+// chunk counts the requests made for a finished or failed chunk
+func (p *progress) chunk(c downloader.ChunkStats) {
+	p.requests += c.Attempts
+	if c.Attempts > 1 {
+		p.retries += c.Attempts - 1
+		p.retried++
+		p.retryWait += c.RetryWait
+	}
+}
+
+// sample folds the speed since the previous sample into the moving
+// average. Bytes resumed from an earlier run never count as speed.
+func (p *progress) sample(now time.Time) {
+	if p.start.IsZero() {
+		return
+	}
+	if p.sampledAt.IsZero() {
+		p.sampledAt, p.sampled = p.start, p.resumed
+	}
+	elapsed := now.Sub(p.sampledAt).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(p.downloaded-p.sampled) / elapsed
+	if p.hasRate {
+		p.rate = p.smoothing*current + (1-p.smoothing)*p.rate
+	} else {
+		p.rate, p.hasRate = current, true
+	}
+	p.sampledAt, p.sampled = now, p.downloaded
+}
+
+// speed is the moving average in bytes per second. Bytes taken back after
+// a failed attempt can pull it below zero for a moment.
+func (p *progress) speed() float64 {
+	return max(p.rate, 0)
+}
+
+func (p *progress) render(now time.Time) {
+	speed := p.speed()
+
+	if p.json {
+		line := jsonProgress{Downloaded: p.downloaded, Total: p.total, Speed: speed, Time: now}
+		if p.total > 0 {
+			line.Percent = float64(p.downloaded) / float64(p.total) * 100
+		}
+		json.NewEncoder(p.out).Encode(line)
+		return
+	}
+
+	line := p.line(speed)
+	if p.tty {
+		p.term.draw(line)
+	} else {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// line describes the progress in one line, with a bar in front on a tty
+func (p *progress) line(speed float64) string {
+	var line string
+	if p.total > 0 {
+		// A server may send more than it announced
+		percent := min(max(float64(p.downloaded)/float64(p.total)*100, 0), 100)
+		eta := "--:--"
+		if speed > 0 {
+			eta = formatDuration(time.Duration(float64(max(p.total-p.downloaded, 0)) / speed * float64(time.Second)))
+		}
+		line = fmt.Sprintf("%6.2f %% %s / %s  %s/s  ETA %s",
+			percent, formatBytes(p.downloaded), formatBytes(p.total), formatBytes(int64(speed)), eta)
+		if p.tty {
+			filled := min(max(int(percent/100*barWidth), 0), barWidth)
+			line = "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "] " + line
+		}
+	} else {
+		line = fmt.Sprintf("%s downloaded  %s/s", formatBytes(p.downloaded), formatBytes(int64(speed)))
+	}
+	return line
+}
+
+// finish ends the progress line so later output starts on a fresh one
+func (p *progress) finish() {
+	if p.tty {
+		p.term.end()
+	}
+}
+
+type jsonSummary struct {
+	OK         bool    `json:"ok"`
+	Error      string  `json:"error,omitempty"`
+	Skipped    string  `json:"skipped,omitempty"`
+	Size       int64   `json:"size"`
+	Downloaded int64   `json:"downloaded"`
+	Elapsed    float64 `json:"elapsed"`
+	Speed      float64 `json:"speed"`
+	Requests   int     `json:"requests"`
+	Retries    int     `json:"retries"`
+	Retried    int     `json:"retried_chunks"`
+	RetryWait  float64 `json:"retry_wait"`
+	Checksum   string  `json:"checksum"`
+}
+
+// summary prints the bytes fetched by this run, how long that took and the
+// average speed. Bytes resumed from an earlier run don't count. The text
+// form is only printed for a finished download, the JSON one always is so
+// scripts can tell how it went.
+func (p *progress) summary(now time.Time, format string, err error, verified bool) {
+	fetched := p.downloaded - p.resumed
+	var elapsed time.Duration
+	if !p.start.IsZero() {
+		elapsed = now.Sub(p.start)
+	}
+	var speed float64
+	if elapsed > 0 {
+		speed = float64(fetched) / elapsed.Seconds()
+	}
+
+	if format == summaryJSON {
+		s := jsonSummary{
+			OK:         err == nil || errors.Is(err, downloader.ErrUpToDate) || errors.Is(err, downloader.ErrExists),
+			Size:       p.total,
+			Downloaded: fetched,
+			Elapsed:    elapsed.Seconds(),
+			Speed:      speed,
+			Requests:   p.requests,
+			Retries:    p.retries,
+			Retried:    p.retried,
+			RetryWait:  p.retryWait.Seconds(),
+			Checksum:   checksumStatus(err, verified),
+		}
+		switch {
+		case err != nil && s.OK:
+			s.Skipped = err.Error()
+		case err != nil:
+			s.Error = err.Error()
+		}
+		json.NewEncoder(p.out).Encode(s)
+		return
+	}
+	if err != nil {
+		return
+	}
+	line := fmt.Sprintf("Download complete: %s in %s (%s/s)",
+		formatBytes(fetched), elapsed.Round(10*time.Millisecond), formatBytes(int64(speed)))
+	if p.requests > 0 {
+		line += fmt.Sprintf(", %d requests", p.requests)
+	}
+	if p.retried > 0 {
+		line += fmt.Sprintf(", %d chunks retried %d times after waiting %s", p.retried, p.retries, p.retryWait.Round(10*time.Millisecond))
+	}
+	fmt.Fprintln(p.out, line)
+}
+
+// interrupted tells how far the download got before it was stopped
+func (p *progress) interrupted(resumable bool) string {
+	done := formatBytes(p.downloaded) + " downloaded"
+	if p.total > 0 {
+		done = fmt.Sprintf("%.0f%% downloaded", 100*float64(p.downloaded)/float64(p.total))
+	}
+	if !resumable {
+		return "Interrupted: " + done
+	}
+	return "Interrupted: " + done + ", resume with the same command."
+}
+
+// checksumStatus is "none" without a checksum to verify against, "ok" or
+// "mismatch" once it has been compared and "not checked" if the download
+// didn't get that far
+func checksumStatus(err error, verified bool) string {
+	var mismatch *downloader.ChecksumMismatchError
+	switch {
+	case !verified:
+		return "none"
+	case err == nil:
+		return "ok"
+	case errors.As(err, &mismatch):
+		return "mismatch"
+	}
+	return "not checked"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := d / time.Hour
+	m := (d % time.Hour) / time.Minute
+	s := (d % time.Minute) / time.Second
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
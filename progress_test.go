@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+func TestProgressSpeedAverage(t *testing.T) {
+	p := &progress{smoothing: 0.5}
+	start := time.Unix(1700000000, 0)
+	p.add(0, 1000000, start)
+
+	// 1000 bytes a second, then a burst of 3000
+	at := start
+	for _, n := range []int{1000, 1000, 1000, 3000} {
+		at = at.Add(time.Second)
+		p.add(n, 1000000, at)
+		p.sample(at)
+	}
+	if want := 0.5*3000 + 0.5*1000; math.Abs(p.speed()-want) > 1e-9 {
+		t.Errorf("speed = %f, want %f", p.speed(), want)
+	}
+
+	// Nothing arrives for a while, the speed decays instead of dropping to 0
+	at = at.Add(time.Second)
+	p.sample(at)
+	if got := p.speed(); got != 1000 {
+		t.Errorf("speed = %f after a quiet second, want 1000", got)
+	}
+}
+
+func TestProgressSpeedIgnoresResumed(t *testing.T) {
+	p := &progress{smoothing: 0.3}
+	start := time.Unix(1700000000, 0)
+	// Half the file was already on disk
+	p.add(500000, 1000000, start)
+	p.add(2000, 1000000, start.Add(time.Second))
+	p.sample(start.Add(time.Second))
+	if got := p.speed(); got != 2000 {
+		t.Errorf("speed = %f, want 2000", got)
+	}
+}
+
+func TestProgressSpeedNotNegative(t *testing.T) {
+	p := &progress{smoothing: 1}
+	start := time.Unix(1700000000, 0)
+	p.add(0, 1000, start)
+	p.add(-500, 1000, start.Add(time.Second))
+	p.sample(start.Add(time.Second))
+	if got := p.speed(); got != 0 {
+		t.Errorf("speed = %f after bytes were taken back, want 0", got)
+	}
+}
+
+func TestProgressSummaryRetries(t *testing.T) {
+	var out bytes.Buffer
+	p := &progress{out: &out}
+	start := time.Unix(1700000000, 0)
+	p.add(1000, 1000, start)
+	p.chunk(downloader.ChunkStats{Attempts: 1})
+	p.chunk(downloader.ChunkStats{Attempts: 3, RetryWait: 1500 * time.Millisecond})
+	p.chunk(downloader.ChunkStats{Attempts: 2, RetryWait: 500 * time.Millisecond})
+
+	p.summary(start.Add(time.Second), summaryText, nil, false)
+	if want := ", 6 requests, 2 chunks retried 3 times after waiting 2s\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("summary %q, want it to end in %q", out.String(), want)
+	}
+
+	out.Reset()
+	p.summary(start.Add(time.Second), summaryJSON, nil, false)
+	var s jsonSummary
+	if err := json.Unmarshal(out.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Requests != 6 || s.Retries != 3 || s.Retried != 2 || s.RetryWait != 2 {
+		t.Errorf("got %+v, want 6 requests, 3 retries of 2 chunks and 2s waiting", s)
+	}
+}
+
+func TestProgressLineOverTotal(t *testing.T) {
+	tests := []struct {
+		name       string
+		downloaded int
+		percent    string
+		bar        string
+	}{
+		{name: "more than announced", downloaded: 200000, percent: "100.00 %", bar: strings.Repeat("=", barWidth)},
+		{name: "taken back", downloaded: -500, percent: "  0.00 %", bar: strings.Repeat(" ", barWidth)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &progress{tty: true}
+			p.add(tt.downloaded, 1000, time.Unix(1700000000, 0))
+			line := p.line(100)
+			if !strings.HasPrefix(line, "["+tt.bar+"] "+tt.percent) {
+				t.Errorf("line %q, want it to start with a bar of %q at %q", line, tt.bar, tt.percent)
+			}
+		})
+	}
+}
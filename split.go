@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+// downloadParts fetches url into name.part000, name.part001 and so on,
+// partSize bytes each
+func downloadParts(ctx context.Context, d *downloader.Downloader, url, name string, partSize uint64, opts downloader.Options) error {
+	if opts.OutputDir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(opts.OutputDir, name)
+	}
+	if !opts.Override {
+		if _, err := os.Stat(downloader.PartName(name, 0)); err == nil {
+			opts.Logger.Error(downloader.PartName(name, 0), "exists make sure the *override* flag is set to continue")
+			return downloader.ErrExists
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	sink, err := downloader.NewPartSink(name, partSize)
+	if err != nil {
+		return err
+	}
+	err = d.DownloadAt(ctx, url, sink, opts)
+	parts := sink.Parts()
+	if closeErr := sink.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		opts.Logger.Info("Wrote", parts, "parts of", name)
+	}
+	return err
+}
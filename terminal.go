@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// terminal owns the progress line at the bottom of a terminal. Log lines
+// written through it clear the progress line first and redraw it after, so
+// the two don't end up mixed on one line.
+type terminal struct {
+	mu sync.Mutex
+	// Where the progress line is drawn and where the log goes, they may
+	// be the same
+	out io.Writer
+	log io.Writer
+	// The progress line on screen, empty if there is none
+	line string
+}
+
+// draw replaces the progress line
+func (t *terminal) draw(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Pad to clear whatever was left over from a longer previous line
+	fmt.Fprintf(t.out, "\r%-80s", line)
+	t.line = line
+}
+
+// end leaves the progress line where it is, later output starts below it
+func (t *terminal) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.line != "" {
+		fmt.Fprintln(t.out)
+		t.line = ""
+	}
+}
+
+func (t *terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.line == "" {
+		return t.log.Write(p)
+	}
+	fmt.Fprint(t.out, "\r"+strings.Repeat(" ", max(len(t.line), 80))+"\r")
+	n, err := t.log.Write(p)
+	fmt.Fprintf(t.out, "\r%-80s", t.line)
+	return n, err
+}
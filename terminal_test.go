@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTerminalClearsProgressForLogs(t *testing.T) {
+	var screen bytes.Buffer
+	term := &terminal{out: &screen, log: &screen}
+	pad := func(s string) string { return fmt.Sprintf("%-80s", s) }
+
+	term.Write([]byte("before\n"))
+	term.draw("10 %")
+	term.Write([]byte("Retrying\n"))
+	term.draw("20 %")
+	term.end()
+	term.Write([]byte("after\n"))
+
+	want := "before\n" +
+		"\r" + pad("10 %") +
+		"\r" + strings.Repeat(" ", 80) + "\r" + "Retrying\n" + "\r" + pad("10 %") +
+		"\r" + pad("20 %") + "\n" +
+		"after\n"
+	if got := screen.String(); got != want {
+		t.Errorf("screen got %q, want %q", got, want)
+	}
+}
+
+func TestTerminalConcurrentWrites(t *testing.T) {
+	var screen bytes.Buffer
+	term := &terminal{out: &screen, log: &screen}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if i == 0 {
+					term.draw(fmt.Sprintf("progress %d", j))
+				} else {
+					fmt.Fprintf(term, "worker %d message %d\n", i, j)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	term.end()
+
+	// Every message sits on a line of its own, after the progress line
+	// was wiped
+	for _, line := range strings.Split(screen.String(), "\n") {
+		if i := strings.LastIndex(line, "\r"); i >= 0 {
+			line = line[i+1:]
+		}
+		if strings.Contains(line, "worker") && !strings.HasPrefix(line, "worker") {
+			t.Errorf("message garbled: %q", line)
+		}
+	}
+}
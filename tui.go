@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+// batchView draws one progress bar per file in flight and a total below
+// them, redrawn in place with ANSI escapes. Like terminal, log lines written
+// through it go above the bars.
+type batchView struct {
+	mu  sync.Mutex
+	out io.Writer
+	log io.Writer
+	// Receives every Status as well, e.g. for -metrics
+	next chan<- downloader.Status
+
+	rows []*batchRow
+	// Lines on screen, to be cleared before the next draw
+	drawn int
+
+	files, finished int
+	// Bytes of the finished files
+	done int64
+	// For the speed of every row, as -smoothing
+	smoothing float64
+
+	stopping, stopped chan struct{}
+}
+
+type batchRow struct {
+	label  string
+	p      *progress
+	status chan downloader.Status
+	// Closed once status is drained
+	drained chan struct{}
+}
+
+func newBatchView(out, log io.Writer, files int, smoothing float64) *batchView {
+	return &batchView{
+		out:       out,
+		log:       log,
+		files:     files,
+		smoothing: smoothing,
+		stopping:  make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// start adds a row for entry, its Download reports on row.status
+func (v *batchView) start(entry manifestEntry) *batchRow {
+	label := entry.name
+	if label == "" {
+		label = path.Base(entry.url)
+	}
+	row := &batchRow{
+		label:   label,
+		p:       &progress{tty: true, smoothing: v.smoothing},
+		status:  make(chan downloader.Status, 1),
+		drained: make(chan struct{}),
+	}
+	v.mu.Lock()
+	v.rows = append(v.rows, row)
+	v.mu.Unlock()
+
+	go func() {
+		defer close(row.drained)
+		for s := range row.status {
+			v.mu.Lock()
+			row.p.add(s.Downloaded, s.Total, s.Time)
+			v.mu.Unlock()
+			if v.next != nil {
+				v.next <- s
+			}
+		}
+	}()
+	return row
+}
+
+// finish removes row once its Download returned
+func (v *batchView) finish(row *batchRow) {
+	v.mu.Lock()
+	v.rows = slices.DeleteFunc(v.rows, func(r *batchRow) bool { return r == row })
+	v.mu.Unlock()
+	// Nothing sends anymore once Download returned
+	close(row.status)
+	<-row.drained
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.finished++
+	v.done += row.p.downloaded
+}
+
+// run redraws the rows until stop is called, then clears them and leaves
+// the total
+func (v *batchView) run() {
+	defer close(v.stopped)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	sampler := time.NewTicker(sampleInterval)
+	defer sampler.Stop()
+	for {
+		select {
+		case now := <-sampler.C:
+			v.mu.Lock()
+			for _, row := range v.rows {
+				row.p.sample(now)
+			}
+			v.mu.Unlock()
+		case <-ticker.C:
+			v.mu.Lock()
+			v.draw()
+			v.mu.Unlock()
+		case <-v.stopping:
+			v.mu.Lock()
+			v.clear()
+			fmt.Fprintln(v.out, v.total())
+			v.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (v *batchView) stop() {
+	close(v.stopping)
+	<-v.stopped
+}
+
+// lines must be called with v.mu held
+func (v *batchView) lines() []string {
+	lines := make([]string, 0, len(v.rows)+1)
+	for _, row := range v.rows {
+		lines = append(lines, row.p.line(row.p.speed())+"  "+row.label)
+	}
+	return append(lines, v.total())
+}
+
+// total must be called with v.mu held
+func (v *batchView) total() string {
+	downloaded, size := v.done, v.done
+	var speed float64
+	for _, row := range v.rows {
+		downloaded += row.p.downloaded
+		size += row.p.total
+		speed += row.p.speed()
+	}
+	return fmt.Sprintf("%d of %d files done, %d in flight  %s / %s  %s/s",
+		v.finished, v.files, len(v.rows), formatBytes(downloaded), formatBytes(size), formatBytes(int64(speed)))
+}
+
+// draw and clear must be called with v.mu held
+func (v *batchView) draw() {
+	v.clear()
+	lines := v.lines()
+	fmt.Fprint(v.out, strings.Join(lines, "\n")+"\n")
+	v.drawn = len(lines)
+}
+
+func (v *batchView) clear() {
+	// Up to the first line drawn, clearing everything below it
+	if v.drawn > 0 {
+		fmt.Fprintf(v.out, "\033[%dA\r\033[J", v.drawn)
+	}
+	v.drawn = 0
+}
+
+func (v *batchView) Write(p []byte) (int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	redraw := v.drawn > 0
+	v.clear()
+	n, err := v.log.Write(p)
+	if redraw {
+		v.draw()
+	}
+	return n, err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/keshavchand/downloader/downloader"
+)
+
+func TestBatchView(t *testing.T) {
+	var screen bytes.Buffer
+	v := newBatchView(&screen, &screen, 3, defaultSmoothing)
+	now := time.Now()
+	a := v.start(manifestEntry{url: "http://example.com/a.iso"})
+	b := v.start(manifestEntry{url: "http://example.com/b", name: "b.bin"})
+	a.status <- downloader.Status{Downloaded: 512 << 10, Total: 1 << 20, Time: now}
+	b.status <- downloader.Status{Downloaded: 1 << 20, Total: 4 << 20, Time: now}
+	v.finish(a)
+
+	// b's Status is taken in the background
+	var lines []string
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		v.mu.Lock()
+		lines = v.lines()
+		seen := b.p.total > 0
+		v.mu.Unlock()
+		if seen || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(lines) != 2 {
+		t.Fatalf("got lines %q, want a row and the total", lines)
+	}
+	if !strings.HasSuffix(lines[0], "b.bin") || !strings.Contains(lines[0], "25.00 %") {
+		t.Errorf("row %q, want b.bin at 25%%", lines[0])
+	}
+	if want := "1 of 3 files done, 1 in flight  1.5 MiB / 4.5 MiB"; !strings.HasPrefix(lines[1], want) {
+		t.Errorf("total %q, want %q", lines[1], want)
+	}
+
+	// A log line clears the two drawn lines and draws them again below it
+	v.mu.Lock()
+	v.draw()
+	v.mu.Unlock()
+	screen.Reset()
+	v.Write([]byte("Retrying\n"))
+	want := "\033[2A\r\033[J" + "Retrying\n" + strings.Join(lines, "\n") + "\n"
+	if got := screen.String(); got != want {
+		t.Errorf("screen got %q, want %q", got, want)
+	}
+	v.finish(b)
+}